@@ -12,6 +12,8 @@ require (
 	golang.org/x/net v0.0.0-20210520170846-37e1c6afe023
 	golang.org/x/sys v0.0.0-20210908233432-aa78b53d3365 // indirect
 	gopkg.in/yaml.v2 v2.4.0
+	k8s.io/api v0.22.1
 	k8s.io/apimachinery v0.22.1
 	k8s.io/client-go v0.22.1
+	k8s.io/metrics v0.22.1
 )
@@ -7,39 +7,53 @@ import (
 	"io"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"path"
 	"syscall"
+	"time"
 
+	"auto-scale/src/controller"
+	"auto-scale/src/discovery"
+	"auto-scale/src/election"
 	"auto-scale/src/handler"
+	"auto-scale/src/overprovision"
+	"auto-scale/src/scale"
 	"auto-scale/src/utils"
 )
 
 const (
 	netType = "udp"
 	bufSize = 1024
+
+	clusterCheckInterval = time.Second * 30
 )
 
 var (
-	buf       [bufSize]byte
-	bufByte   bytes.Buffer
-	config    *utils.Config
-	configPath string
+	buf         [bufSize]byte
+	bufByte     bytes.Buffer
+	config      *utils.Config
+	configPath  string
+	kubeconfig  string
+	kubeContext string
 )
 
 func init() {
 	flag.StringVar(&configPath, "config", "config.yaml", "config path ...")
+	flag.StringVar(&kubeconfig, "kubeconfig", "", "kubeconfig of the default cluster, in-cluster config or ~/.kube/config if empty")
+	flag.StringVar(&kubeContext, "context", "", "kubeconfig context of the default cluster")
 	flag.Parse()
 	pwd, _ := os.Getwd()
 	cfg := path.Join(pwd, configPath)
 	// log.SetFlags(log.Ldate | log.Lmicroseconds | log.Llongfile)
 	config = utils.NewConfig(cfg)
-	if config.ScaleServices == nil || len(config.ScaleServices) == 0 {
+	if (config.ScaleServices == nil || len(config.ScaleServices) == 0) && !config.Discovery && !config.Controller &&
+		config.Mode != utils.ModeAgent {
 		log.Fatalln("WARNING, Auto scale dest service not defined")
 	}
 	go func() {
-		quitChan := make(chan os.Signal)
+		quitChan := make(chan os.Signal, 1)
 		signal.Notify(quitChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGILL)
 		c := <-quitChan
 		log.Println("received ", c)
@@ -61,12 +75,25 @@ func main() {
 	}
 	defer conn.Close()
 	log.Printf("App listen on %s/%s", listenAddr, netType)
+	go func() {
+		// expvar指标在 /debug/vars
+		httpAddr := fmt.Sprintf("%s:%d", config.Listen.ListenAddr, config.Listen.HttpPort)
+		log.Printf("http listen on %s", httpAddr)
+		log.Println(http.ListenAndServe(httpAddr, nil))
+	}()
 	for _, conf := range config.ScaleServices {
-		log.Printf("service %s.%s, safeQps=%.2f, maxQps=%.2f, minPod=%d, maxPod=%d factor=%.1f",
-			conf.ServiceName, conf.Namespace, conf.SafeQps, conf.MaxQps, conf.MinPod, conf.MaxPod, conf.Factor)
+		log.Printf("service %s.%s, safeQps=%.2f, maxQps=%.2f, minPod=%d, maxPod=%d factor=%.1f targetRefs=%v dryRun=%t",
+			conf.ServiceName, conf.Namespace, conf.SafeQps, conf.MaxQps, conf.MinPod, conf.MaxPod, conf.Factor,
+			conf.TargetRefs, *conf.DryRun)
 	}
 	log.Printf("forward origin message to %s", config.Forwards)
-	poolHandler := handler.NewPoolHandler(config)
+	var poolHandler *handler.PoolHandler
+	if config.Mode == utils.ModeAgent {
+		// agent只解析日志并上报计数,不访问Kubernetes
+		poolHandler = handler.NewPoolHandler(config, nil)
+	} else {
+		poolHandler = startScaling()
+	}
 	forward := utils.NewForward(config.Forwards)
 	for {
 		n, err := conn.Read(buf[:])
@@ -87,3 +114,64 @@ func main() {
 		bufByte.Reset()
 	}
 }
+
+func startScaling() *handler.PoolHandler {
+	if !config.NeedKubernetes() {
+		// 所有服务都通过exec或webhook伸缩,不需要kubeconfig
+		poolHandler := handler.NewPoolHandler(config, nil)
+		handleAPIs(poolHandler)
+		log.Println("no service scaled by kubernetes, skip kubernetes client")
+		return poolHandler
+	}
+	scale.AddCluster(scale.ClusterConfig{Name: scale.DefaultCluster, Kubeconfig: kubeconfig, Context: kubeContext})
+	for _, cluster := range config.Clusters {
+		scale.AddCluster(scale.ClusterConfig{Name: cluster.Name, Kubeconfig: cluster.Kubeconfig, Context: cluster.Context})
+	}
+	client := scale.NewK8SClient()
+	go scale.CheckClusters(clusterCheckInterval, make(chan struct{}))
+	poolHandler := handler.NewPoolHandler(config, client)
+	handleAPIs(poolHandler)
+	services := make([]string, len(config.ScaleServices))
+	for i, conf := range config.ScaleServices {
+		services[i] = fmt.Sprintf("%s.%s", conf.ServiceName, conf.Namespace)
+	}
+	if !poolHandler.Preflight(services...) && config.RBACFailFast {
+		log.Fatalln("missing RBAC permissions, exit because rbacFailFast is set")
+	}
+	if config.HA.Enabled {
+		// 只有leader执行伸缩,其他副本通过RecordsPath把统计结果转发给leader
+		http.Handle(handler.RecordsPath, poolHandler)
+		elector := election.NewElector(client.Clientset(), config.HA.LeaseNamespace, config.HA.LeaseName, config.HA.Address)
+		poolHandler.SetElector(elector, config.HA.Address)
+		elector.Run(make(chan struct{}))
+		log.Printf("high availability enabled, lease %s/%s, identity %s",
+			config.HA.LeaseNamespace, config.HA.LeaseName, config.HA.Address)
+	}
+	if config.Discovery {
+		discovery.NewWatcher(client.Clientset(), config, poolHandler).Run(make(chan struct{}))
+	}
+	if config.Controller {
+		ctrl := controller.NewController(client.Dynamic(), config, poolHandler)
+		poolHandler.AddObserver(ctrl)
+		ctrl.Run(make(chan struct{}))
+	}
+	if config.Overprovision.Enabled {
+		// 占位Pod优先级最低,cluster autoscaler为它们准备的节点会被真正的Pod抢占
+		poolHandler.AddObserver(overprovision.NewProvisioner(client, poolHandler, config))
+		log.Printf("overprovision with placeholder %s/%s, at most %d pods",
+			config.Overprovision.Namespace, config.Overprovision.Name, config.Overprovision.MaxPods)
+	}
+	return poolHandler
+}
+
+// handleAPIs 注册aggregator和管理接口
+func handleAPIs(poolHandler *handler.PoolHandler) {
+	if config.Mode == utils.ModeAggregator {
+		http.HandleFunc(handler.CountsPath, poolHandler.ServeCounts)
+		log.Printf("aggregator mode, receive counts from agents on %s", handler.CountsPath)
+	}
+	if config.Admin.Enabled {
+		http.Handle(handler.AdminPath, poolHandler.AdminHandler(config.Admin.Token))
+		log.Printf("admin api enabled on %s", handler.AdminPath)
+	}
+}
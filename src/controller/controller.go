@@ -0,0 +1,278 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+
+	"auto-scale/src/handler"
+	"auto-scale/src/utils"
+)
+
+const (
+	resync = time.Minute * 10
+	// 没有伸缩时status最多每statusInterval更新一次,避免频繁写apiserver
+	statusInterval = time.Second * 30
+)
+
+// Registry 开始或停止一个服务的自动伸缩,读取服务当前的副本数,由handler.PoolHandler实现
+type Registry interface {
+	AddService(serviceName string)
+	RemoveService(serviceName string)
+	Replicas(serviceName string) (int32, error)
+}
+
+func NewController(client dynamic.Interface, config *utils.Config, registry Registry) *Controller {
+	return &Controller{
+		client:   client,
+		config:   config,
+		registry: registry,
+		factory:  dynamicinformer.NewDynamicSharedInformerFactory(client, resync),
+		objects:  make(map[string]*object),
+	}
+}
+
+// Controller 将SimpleHPA同步为PoolHandler中的服务,并把QPS和伸缩结果写回status
+type Controller struct {
+	mutex    sync.Mutex
+	client   dynamic.Interface
+	config   *utils.Config
+	registry Registry
+	factory  dynamicinformer.DynamicSharedInformerFactory
+	objects  map[string]*object // key为namespace/name
+}
+
+type object struct {
+	namespace   string
+	name        string
+	serviceName string // svc.namespace
+	generation  int64
+	registered  bool
+	dirty       bool
+	status      SimpleHPAStatus
+}
+
+func (c *Controller) Run(stop <-chan struct{}) {
+	c.factory.ForResource(Resource).Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.sync,
+		UpdateFunc: func(_, obj interface{}) {
+			c.sync(obj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			c.remove(key)
+		},
+	})
+	c.factory.Start(stop)
+	go func() {
+		ticker := time.NewTicker(statusInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.flushAll()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	log.Printf("watch %s.%s/%s", Resource.Resource, Group, Version)
+}
+
+func (c *Controller) sync(obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	shpa := new(SimpleHPA)
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, shpa); err != nil {
+		log.Printf("convert %s/%s error %v", u.GetNamespace(), u.GetName(), err)
+		return
+	}
+	key := shpa.Namespace + "/" + shpa.Name
+	service := shpa.Spec.ServiceName
+	if service == "" {
+		service = shpa.Name
+	}
+	serviceName := fmt.Sprintf("%s.%s", service, shpa.Namespace)
+	c.mutex.Lock()
+	obj_, ok := c.objects[key]
+	if ok && obj_.generation == shpa.Generation {
+		// 只有status变化
+		c.mutex.Unlock()
+		return
+	}
+	c.mutex.Unlock()
+	if ok && obj_.serviceName != serviceName {
+		c.remove(key)
+	}
+	spec := &utils.ServiceSpec{
+		Namespace:   shpa.Namespace,
+		ServiceName: service,
+		MinPod:      shpa.Spec.MinReplicas,
+		MaxPod:      shpa.Spec.MaxReplicas,
+		MaxQps:      float32(shpa.Spec.MaxQps),
+		SafeQps:     float32(shpa.Spec.SafeQps),
+		Factor:      float32(shpa.Spec.Factor),
+	}
+	if ref := shpa.Spec.TargetRef; ref != nil {
+		spec.APIVersion, spec.Kind, spec.Name = ref.APIVersion, ref.Kind, ref.Name
+	}
+	if shpa.Spec.Policies != nil {
+		spec.DryRun = shpa.Spec.Policies.DryRun
+	}
+	condition := metav1.Condition{
+		Type:               ConditionValidSpec,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Accepted",
+		ObservedGeneration: shpa.Generation,
+	}
+	registered := false
+	conf, err := c.config.NewDynamicConfig(spec)
+	if err != nil {
+		condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, "InvalidSpec", err.Error()
+	} else if !c.config.AddDiscoveredService(conf) {
+		condition.Status, condition.Reason = metav1.ConditionFalse, "ConfigFileOverride"
+		condition.Message = serviceName + " is configured in scaleServices, which takes precedence"
+	} else {
+		registered = true
+	}
+	c.mutex.Lock()
+	obj_, ok = c.objects[key]
+	if !ok {
+		obj_ = &object{namespace: shpa.Namespace, name: shpa.Name, status: shpa.Status}
+		c.objects[key] = obj_
+	}
+	obj_.serviceName = serviceName
+	obj_.generation = shpa.Generation
+	// 改为无效的spec后停止伸缩,不继续使用上一个有效的配置
+	unregister := obj_.registered && !registered
+	obj_.registered = registered
+	meta.SetStatusCondition(&obj_.status.Conditions, condition)
+	obj_.dirty = true
+	c.mutex.Unlock()
+	if registered {
+		log.Printf("SimpleHPA %s, config %s", key, conf)
+		c.registry.AddService(serviceName)
+	} else {
+		log.Printf("WARN SimpleHPA %s not accepted: %s", key, condition.Message)
+		if unregister && c.config.RemoveDiscoveredService(serviceName) {
+			log.Printf("stop auto scaling %s", serviceName)
+			c.registry.RemoveService(serviceName)
+		}
+	}
+	c.flush(key)
+}
+
+func (c *Controller) remove(key string) {
+	c.mutex.Lock()
+	obj, ok := c.objects[key]
+	delete(c.objects, key)
+	c.mutex.Unlock()
+	if !ok || !obj.registered || !c.config.RemoveDiscoveredService(obj.serviceName) {
+		return
+	}
+	log.Printf("SimpleHPA %s is deleted, remove %s from auto scale", key, obj.serviceName)
+	c.registry.RemoveService(obj.serviceName)
+}
+
+// Observe 记录每个采样周期的结果,伸缩时立即更新status
+func (c *Controller) Observe(observation *handler.Observation) {
+	// 没有伸缩时读取当前副本数,status中的currentReplicas不会过时
+	var current int32
+	var err error
+	if change := observation.Change; change != nil {
+		current = change.New
+	} else if current, err = c.registry.Replicas(observation.ServiceName); err != nil {
+		log.Printf("get %s replicas error %v", observation.ServiceName, err)
+	}
+	c.mutex.Lock()
+	var key string
+	for k, obj := range c.objects {
+		if obj.serviceName != observation.ServiceName || !obj.registered {
+			continue
+		}
+		key = k
+		obj.status.ObservedQps = fmt.Sprintf("%.2f", observation.Qps)
+		obj.status.DesiredReplicas = observation.Desired
+		if err == nil {
+			obj.status.CurrentReplicas = current
+		}
+		if change := observation.Change; change != nil {
+			now := metav1.Now()
+			obj.status.LastScaleTime = &now
+			meta.SetStatusCondition(&obj.status.Conditions, metav1.Condition{
+				Type:               ConditionScalingActive,
+				Status:             metav1.ConditionTrue,
+				Reason:             "Scaled",
+				Message:            change.String(),
+				ObservedGeneration: obj.generation,
+			})
+		}
+		if observation.Err != nil {
+			meta.SetStatusCondition(&obj.status.Conditions, metav1.Condition{
+				Type:               ConditionScalingActive,
+				Status:             metav1.ConditionFalse,
+				Reason:             "ScaleFailed",
+				Message:            observation.Err.Error(),
+				ObservedGeneration: obj.generation,
+			})
+		}
+		obj.dirty = true
+	}
+	c.mutex.Unlock()
+	if key != "" && (observation.Change != nil || observation.Err != nil) {
+		go c.flush(key)
+	}
+}
+
+func (c *Controller) flushAll() {
+	c.mutex.Lock()
+	keys := make([]string, 0, len(c.objects))
+	for key := range c.objects {
+		keys = append(keys, key)
+	}
+	c.mutex.Unlock()
+	for _, key := range keys {
+		c.flush(key)
+	}
+}
+
+// flush 通过status子资源写回状态
+func (c *Controller) flush(key string) {
+	c.mutex.Lock()
+	obj, ok := c.objects[key]
+	if !ok || !obj.dirty {
+		c.mutex.Unlock()
+		return
+	}
+	obj.dirty = false
+	namespace, name := obj.namespace, obj.name
+	patch, err := json.Marshal(map[string]interface{}{"status": obj.status})
+	c.mutex.Unlock()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	_, err = c.client.Resource(Resource).Namespace(namespace).Patch(context.TODO(), name,
+		types.MergePatchType, patch, metav1.PatchOptions{}, "status")
+	if err != nil {
+		log.Printf("update SimpleHPA %s status error %v", key, err)
+	}
+}
@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic/fake"
+
+	"auto-scale/src/handler"
+	"auto-scale/src/scale"
+	"auto-scale/src/utils"
+)
+
+const testReplicas = 3
+
+// registryEvents 按顺序记录Controller对Registry的调用,如"add web.demo"
+type registryEvents chan string
+
+func (e registryEvents) AddService(serviceName string) {
+	e <- "add " + serviceName
+}
+
+func (e registryEvents) RemoveService(serviceName string) {
+	e <- "remove " + serviceName
+}
+
+// Replicas 服务当前的副本数固定为testReplicas
+func (e registryEvents) Replicas(serviceName string) (int32, error) {
+	return testReplicas, nil
+}
+
+func (e registryEvents) expect(t *testing.T, want string) {
+	select {
+	case got := <-e:
+		if got != want {
+			t.Fatalf("want %s, got %s", want, got)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("timeout waiting for %s", want)
+	}
+}
+
+func newSimpleHPA(name string, spec SimpleHPASpec) *unstructured.Unstructured {
+	shpa := &SimpleHPA{
+		TypeMeta:   metav1.TypeMeta{APIVersion: Group + "/" + Version, Kind: Kind},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "demo", Generation: 1},
+		Spec:       spec,
+	}
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(shpa)
+	if err != nil {
+		panic(err)
+	}
+	return &unstructured.Unstructured{Object: obj}
+}
+
+func newTestController(objects ...runtime.Object) (*Controller, registryEvents, *fake.FakeDynamicClient) {
+	scheme := runtime.NewScheme()
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(scheme,
+		map[schema.GroupVersionResource]string{Resource: Kind + "List"}, objects...)
+	config := &utils.Config{Default: &utils.DefaultConfig{MaxPod: 2, MinPod: 1, MaxQps: 10, SafeQps: 5, Factor: 1}}
+	events := make(registryEvents, 10)
+	return NewController(client, config, events), events, client
+}
+
+func getStatus(t *testing.T, client *fake.FakeDynamicClient, name string) *SimpleHPAStatus {
+	u, err := client.Resource(Resource).Namespace("demo").Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shpa := new(SimpleHPA)
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, shpa); err != nil {
+		t.Fatal(err)
+	}
+	return &shpa.Status
+}
+
+// waitForStatus 等待Controller把满足cond的status写回
+func waitForStatus(t *testing.T, client *fake.FakeDynamicClient, name string, cond func(*SimpleHPAStatus) bool) {
+	err := wait.PollImmediate(time.Millisecond*100, time.Second*5, func() (bool, error) {
+		return cond(getStatus(t, client, name)), nil
+	})
+	if err != nil {
+		t.Fatalf("%s status %v", name, err)
+	}
+}
+
+func TestController_Run(t *testing.T) {
+	ctrl, events, client := newTestController(
+		newSimpleHPA("web", SimpleHPASpec{MaxReplicas: 6, MaxQps: 20, SafeQps: 10}),
+		newSimpleHPA("bad", SimpleHPASpec{MaxQps: 5, SafeQps: 10}),
+	)
+	stop := make(chan struct{})
+	defer close(stop)
+	ctrl.Run(stop)
+	events.expect(t, "add web.demo")
+	if conf := ctrl.config.GetServiceConfig("web.demo"); conf.MaxPod != 6 || conf.MinPod != 1 || len(conf.TargetRefs) != 0 {
+		t.Errorf("unexpected web.demo config %+v", conf)
+	}
+	if !meta.IsStatusConditionTrue(getStatus(t, client, "web").Conditions, ConditionValidSpec) {
+		t.Error("want web ValidSpec true")
+	}
+	waitForStatus(t, client, "bad", func(status *SimpleHPAStatus) bool {
+		return meta.IsStatusConditionFalse(status.Conditions, ConditionValidSpec)
+	})
+	if len(events) > 0 {
+		t.Errorf("invalid spec should not be added, got %s", <-events)
+	}
+
+	err := client.Resource(Resource).Namespace("demo").Delete(context.TODO(), "web", metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events.expect(t, "remove web.demo")
+	if ctrl.config.GetServiceConfig("web.demo") != nil {
+		t.Error("want web.demo config removed")
+	}
+}
+
+func TestController_Observe(t *testing.T) {
+	ctrl, _, client := newTestController(newSimpleHPA("web", SimpleHPASpec{MaxReplicas: 6}))
+	u, err := client.Resource(Resource).Namespace("demo").Get(context.TODO(), "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctrl.sync(u)
+	ctrl.Observe(&handler.Observation{
+		ServiceName: "web.demo",
+		Qps:         31.5,
+		Desired:     4,
+		Change:      &scale.Change{ServiceName: "web.demo", Old: 2, New: 4},
+	})
+	waitForStatus(t, client, "web", func(status *SimpleHPAStatus) bool { return status.CurrentReplicas == 4 })
+	status := getStatus(t, client, "web")
+	if status.ObservedQps != "31.50" || status.DesiredReplicas != 4 || status.LastScaleTime == nil {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if !meta.IsStatusConditionTrue(status.Conditions, ConditionScalingActive) {
+		t.Error("want ScalingActive true")
+	}
+
+	// 没有伸缩时currentReplicas为读取到的副本数
+	ctrl.Observe(&handler.Observation{ServiceName: "web.demo", Desired: 5, Err: errors.New("forbidden")})
+	waitForStatus(t, client, "web", func(status *SimpleHPAStatus) bool {
+		return meta.IsStatusConditionFalse(status.Conditions, ConditionScalingActive) && status.CurrentReplicas == testReplicas
+	})
+}
+
+func TestController_InvalidUpdate(t *testing.T) {
+	valid := newSimpleHPA("web", SimpleHPASpec{MaxReplicas: 6, MaxQps: 20, SafeQps: 10})
+	ctrl, events, _ := newTestController(valid)
+	ctrl.sync(valid)
+	events.expect(t, "add web.demo")
+	invalid := newSimpleHPA("web", SimpleHPASpec{MaxReplicas: 6, MaxQps: 5, SafeQps: 10})
+	invalid.SetGeneration(2)
+	ctrl.sync(invalid)
+	events.expect(t, "remove web.demo")
+	if ctrl.config.GetServiceConfig("web.demo") != nil {
+		t.Error("invalid spec should stop scaling")
+	}
+}
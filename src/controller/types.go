@@ -0,0 +1,60 @@
+package controller
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	Group   = "simple-hpa.io"
+	Version = "v1alpha1"
+	Kind    = "SimpleHPA"
+
+	// Status.Conditions的类型
+	ConditionValidSpec     = "ValidSpec"
+	ConditionScalingActive = "ScalingActive"
+)
+
+var Resource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "simplehpas"}
+
+// SimpleHPA 与config.yaml中的scaleServices作用相同,各团队可以在自己的namespace中管理
+type SimpleHPA struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SimpleHPASpec   `json:"spec"`
+	Status SimpleHPAStatus `json:"status,omitempty"`
+}
+
+type SimpleHPASpec struct {
+	// Ingress日志中的Service名,默认与SimpleHPA同名
+	ServiceName string `json:"serviceName,omitempty"`
+	// 伸缩的工作负载,未设置时通过Service的selector查找
+	TargetRef   *TargetRef `json:"targetRef,omitempty"`
+	MinReplicas int32      `json:"minReplicas,omitempty"`
+	MaxReplicas int32      `json:"maxReplicas,omitempty"`
+	MaxQps      float64    `json:"maxQps,omitempty"`
+	SafeQps     float64    `json:"safeQps,omitempty"`
+	Factor      float64    `json:"factor,omitempty"`
+	Policies    *Policies  `json:"policies,omitempty"`
+}
+
+type TargetRef struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+}
+
+type Policies struct {
+	// 试运行,只记录不修改副本数
+	DryRun *bool `json:"dryRun,omitempty"`
+}
+
+type SimpleHPAStatus struct {
+	// 保留两位小数的字符串,方便kubectl get显示
+	ObservedQps     string             `json:"observedQps,omitempty"`
+	CurrentReplicas int32              `json:"currentReplicas,omitempty"`
+	DesiredReplicas int32              `json:"desiredReplicas,omitempty"`
+	LastScaleTime   *metav1.Time       `json:"lastScaleTime,omitempty"`
+	Conditions      []metav1.Condition `json:"conditions,omitempty"`
+}
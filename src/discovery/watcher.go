@@ -0,0 +1,135 @@
+package discovery
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"auto-scale/src/utils"
+)
+
+const resync = time.Minute * 10
+
+// Registry 开始或停止一个服务的自动伸缩,由handler.PoolHandler实现
+type Registry interface {
+	AddService(serviceName string)
+	RemoveService(serviceName string)
+}
+
+func NewWatcher(clientset kubernetes.Interface, config *utils.Config, registry Registry) *Watcher {
+	return &Watcher{
+		config:   config,
+		registry: registry,
+		factory:  informers.NewSharedInformerFactory(clientset, resync),
+		owners:   make(map[string]string),
+	}
+}
+
+// Watcher 监听带有simple-hpa.io/enabled注解的Deployment和Service,动态加入或移除自动伸缩
+type Watcher struct {
+	mutex    sync.Mutex
+	config   *utils.Config
+	registry Registry
+	factory  informers.SharedInformerFactory
+	owners   map[string]string // 对象key -> 对应的服务名svc.namespace
+}
+
+func (w *Watcher) Run(stop <-chan struct{}) {
+	w.factory.Core().V1().Services().Informer().AddEventHandler(w.eventHandler("Service"))
+	w.factory.Apps().V1().Deployments().Informer().AddEventHandler(w.eventHandler("Deployment"))
+	w.factory.Start(stop)
+	log.Println("watch Deployments and Services with annotation", utils.AnnotationEnabled)
+}
+
+func (w *Watcher) eventHandler(kind string) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: w.sync,
+		UpdateFunc: func(oldObj, obj interface{}) {
+			oldMeta, err1 := meta.Accessor(oldObj)
+			newMeta, err2 := meta.Accessor(obj)
+			if err1 == nil && err2 == nil && oldMeta.GetResourceVersion() == newMeta.GetResourceVersion() {
+				// 定时resync,没有变化
+				return
+			}
+			w.sync(obj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			w.remove(kind + "/" + key)
+		},
+	}
+}
+
+func (w *Watcher) sync(obj interface{}) {
+	var (
+		key, namespace, service, kind, name string
+		annotations                         map[string]string
+	)
+	switch o := obj.(type) {
+	case *corev1.Service:
+		key, namespace, service, annotations = "Service/"+o.Namespace+"/"+o.Name, o.Namespace, o.Name, o.Annotations
+	case *appsv1.Deployment:
+		key, namespace, annotations = "Deployment/"+o.Namespace+"/"+o.Name, o.Namespace, o.Annotations
+		kind, name = "Deployment", o.Name
+		service = o.Annotations[utils.AnnotationService]
+		if service == "" {
+			service = o.Name
+		}
+	default:
+		return
+	}
+	conf, err := w.config.NewAnnotationConfig(namespace, service, "apps/v1", kind, name, annotations)
+	if err != nil {
+		log.Printf("WARN %s annotations error, keep the last config: %v", key, err)
+		return
+	}
+	if conf == nil {
+		w.remove(key)
+		return
+	}
+	serviceName := fmt.Sprintf("%s.%s", service, namespace)
+	w.mutex.Lock()
+	old, ok := w.owners[key]
+	w.mutex.Unlock()
+	if ok && old != serviceName {
+		w.remove(key)
+	}
+	w.mutex.Lock()
+	w.owners[key] = serviceName
+	w.mutex.Unlock()
+	if !w.config.AddDiscoveredService(conf) {
+		return
+	}
+	log.Printf("discovered %s from %s, config %s", serviceName, key, conf)
+	w.registry.AddService(serviceName)
+}
+
+// remove 同一个服务可能同时被Service和Deployment的注解开启,都没有时才移除
+func (w *Watcher) remove(key string) {
+	w.mutex.Lock()
+	serviceName, ok := w.owners[key]
+	delete(w.owners, key)
+	for _, other := range w.owners {
+		if other == serviceName {
+			ok = false
+		}
+	}
+	w.mutex.Unlock()
+	if !ok || !w.config.RemoveDiscoveredService(serviceName) {
+		return
+	}
+	log.Printf("%s is removed from auto scale, %s is deleted or disabled", serviceName, key)
+	w.registry.RemoveService(serviceName)
+}
@@ -0,0 +1,82 @@
+package discovery
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"auto-scale/src/utils"
+)
+
+// discovered 记录Watcher当前注册的服务
+type discovered struct {
+	mutex    sync.Mutex
+	services map[string]bool
+}
+
+func (d *discovered) AddService(serviceName string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.services[serviceName] = true
+}
+
+func (d *discovered) RemoveService(serviceName string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.services, serviceName)
+}
+
+// wait 等到每个服务的注册状态与want一致
+func (d *discovered) wait(t *testing.T, want map[string]bool) {
+	err := wait.PollImmediate(time.Millisecond*100, time.Second*5, func() (bool, error) {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		for serviceName, registered := range want {
+			if d.services[serviceName] != registered {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("want services %v, got %v", want, d.services)
+	}
+}
+
+func TestWatcher_Run(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{
+			Name: "web", Namespace: "demo", Annotations: map[string]string{utils.AnnotationEnabled: "true"},
+		}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+			Name: "api-v2", Namespace: "demo", Annotations: map[string]string{
+				utils.AnnotationEnabled: "true", utils.AnnotationService: "api", "simple-hpa.io/max-pod": "6",
+			},
+		}},
+	)
+	config := &utils.Config{Default: &utils.DefaultConfig{MaxPod: 2, MinPod: 1, MaxQps: 10, SafeQps: 5, Factor: 1}}
+	registry := &discovered{services: make(map[string]bool)}
+	stop := make(chan struct{})
+	defer close(stop)
+	NewWatcher(clientset, config, registry).Run(stop)
+	registry.wait(t, map[string]bool{"web.demo": true, "api.demo": true})
+	if conf := config.GetServiceConfig("api.demo"); conf.MaxPod != 6 || conf.TargetRefs[0].Name != "api-v2" {
+		t.Errorf("unexpected api.demo config %+v", conf)
+	}
+
+	err := clientset.CoreV1().Services("demo").Delete(context.TODO(), "web", metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.wait(t, map[string]bool{"web.demo": false, "api.demo": true})
+	if config.GetServiceConfig("web.demo") != nil {
+		t.Error("want web.demo config removed")
+	}
+}
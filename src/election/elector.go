@@ -0,0 +1,90 @@
+package election
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	leaseDuration = time.Second * 15
+	renewDeadline = time.Second * 10
+	retryPeriod   = time.Second * 2
+)
+
+// NewElector identity为本副本的HTTP地址,follower通过Leader()找到leader
+func NewElector(clientset kubernetes.Interface, namespace, name, identity string) *Elector {
+	return &Elector{
+		identity: identity,
+		lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Client:     clientset.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+		},
+	}
+}
+
+// Elector 通过Lease选举,同一时间只有一个副本执行伸缩
+type Elector struct {
+	mutex    sync.RWMutex
+	identity string
+	leader   string
+	lock     resourcelock.Interface
+}
+
+func (e *Elector) Run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	go func() {
+		// 失去leader后重新参加选举
+		for ctx.Err() == nil {
+			leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+				Lock:            e.lock,
+				LeaseDuration:   leaseDuration,
+				RenewDeadline:   renewDeadline,
+				RetryPeriod:     retryPeriod,
+				ReleaseOnCancel: true,
+				Callbacks: leaderelection.LeaderCallbacks{
+					OnStartedLeading: func(context.Context) {
+						log.Printf("%s became leader, start scaling", e.identity)
+					},
+					OnStoppedLeading: func() {
+						log.Printf("%s lost leadership, forward counts to the new leader", e.identity)
+						e.setLeader("")
+					},
+					OnNewLeader: func(identity string) {
+						log.Printf("current leader is %s", identity)
+						e.setLeader(identity)
+					},
+				},
+			})
+		}
+	}()
+}
+
+func (e *Elector) setLeader(identity string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.leader = identity
+}
+
+// Leader 当前leader的地址,未选出时为空
+func (e *Elector) Leader() string {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.leader
+}
+
+func (e *Elector) IsLeader() bool {
+	leader := e.Leader()
+	return leader != "" && leader == e.identity
+}
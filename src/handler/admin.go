@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"auto-scale/src/scale"
+)
+
+// AdminPath 管理接口的前缀,与/debug/vars共用httpPort
+const AdminPath = "/admin/"
+
+// AdminHandler 暂停、恢复、固定副本数、临时阈值和冻结窗口。token不为空时需要Authorization: Bearer <token>。
+// 开启多副本时只有leader接受请求
+func (ph *PoolHandler) AdminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(AdminPath+"overrides", ph.adminOverrides)
+	mux.HandleFunc(AdminPath+"pause", ph.adminPause)
+	mux.HandleFunc(AdminPath+"resume", ph.adminResume)
+	mux.HandleFunc(AdminPath+"pin", ph.adminPin)
+	mux.HandleFunc(AdminPath+"thresholds", ph.adminThresholds)
+	mux.HandleFunc(AdminPath+"freeze", ph.adminFreeze)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		// 人工干预只在leader的内存中生效,follower上修改会被leader覆盖
+		if !ph.isLeader() {
+			ph.mutex.RLock()
+			leader := ph.elector.Leader()
+			ph.mutex.RUnlock()
+			if leader == "" {
+				http.Error(w, "no leader elected", http.StatusServiceUnavailable)
+				return
+			}
+			http.Error(w, "not the leader, send admin requests to "+leader, http.StatusMisdirectedRequest)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// adminService 取得请求中的服务名,allowAll时为空表示所有服务
+func (ph *PoolHandler) adminService(w http.ResponseWriter, r *http.Request, allowAll bool) (string, bool) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return "", false
+	}
+	service := r.URL.Query().Get("service")
+	if service == "" || service == scale.AllServices {
+		if !allowAll {
+			http.Error(w, "service is required", http.StatusBadRequest)
+			return "", false
+		}
+		return scale.AllServices, true
+	}
+	if ph.config.GetServiceConfig(service) == nil {
+		http.Error(w, service+" is not auto scaled", http.StatusNotFound)
+		return "", false
+	}
+	return service, true
+}
+
+// adminUntil duration为空时一直有效
+func adminUntil(w http.ResponseWriter, r *http.Request) (*time.Time, bool) {
+	duration := r.URL.Query().Get("duration")
+	if duration == "" {
+		return nil, true
+	}
+	d, err := time.ParseDuration(duration)
+	if err != nil || d <= 0 {
+		http.Error(w, "invalid duration "+duration, http.StatusBadRequest)
+		return nil, false
+	}
+	until := time.Now().Add(d)
+	return &until, true
+}
+
+func untilString(until *time.Time) string {
+	if until == nil {
+		return "until resumed"
+	}
+	return "until " + until.Format(time.RFC3339)
+}
+
+// adminDone 记录并通知每一次人工干预,返回服务当前的状态
+func (ph *PoolHandler) adminDone(w http.ResponseWriter, r *http.Request, service, msg string) {
+	msg = fmt.Sprintf("[admin] %s %s by %s", service, msg, r.RemoteAddr)
+	log.Println(msg)
+	ph.notify(msg)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ph.adjuster.Overrides()[service]); err != nil {
+		log.Println(err)
+	}
+}
+
+func (ph *PoolHandler) adminOverrides(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ph.adjuster.Overrides()); err != nil {
+		log.Println(err)
+	}
+}
+
+func (ph *PoolHandler) adminPause(w http.ResponseWriter, r *http.Request) {
+	service, ok := ph.adminService(w, r, true)
+	if !ok {
+		return
+	}
+	until, ok := adminUntil(w, r)
+	if !ok {
+		return
+	}
+	ph.adjuster.Pause(service, until)
+	ph.adminDone(w, r, service, "paused "+untilString(until))
+}
+
+func (ph *PoolHandler) adminResume(w http.ResponseWriter, r *http.Request) {
+	service, ok := ph.adminService(w, r, true)
+	if !ok {
+		return
+	}
+	ph.adjuster.Resume(service)
+	ph.adminDone(w, r, service, "resumed")
+}
+
+func (ph *PoolHandler) adminPin(w http.ResponseWriter, r *http.Request) {
+	service, ok := ph.adminService(w, r, false)
+	if !ok {
+		return
+	}
+	replicas, err := strconv.Atoi(r.URL.Query().Get("replicas"))
+	if err != nil || replicas < 0 {
+		http.Error(w, "invalid replicas", http.StatusBadRequest)
+		return
+	}
+	until, ok := adminUntil(w, r)
+	if !ok {
+		return
+	}
+	change, err := ph.adjuster.Pin(service, int32(replicas), until)
+	if err != nil {
+		log.Println(err)
+		ph.notify(fmt.Sprintf("%s pin to %d failed: %v", service, replicas, err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if change != nil {
+		ph.observe(&Observation{ServiceName: service, Desired: change.New, Change: change})
+	}
+	ph.adminDone(w, r, service, fmt.Sprintf("pinned to %d %s", replicas, untilString(until)))
+}
+
+func (ph *PoolHandler) adminThresholds(w http.ResponseWriter, r *http.Request) {
+	service, ok := ph.adminService(w, r, false)
+	if !ok {
+		return
+	}
+	if r.Method == http.MethodDelete {
+		ph.adjuster.SetThresholds(service, 0, 0, nil)
+		ph.adminDone(w, r, service, "thresholds restored")
+		return
+	}
+	maxQps, err1 := strconv.ParseFloat(r.URL.Query().Get("maxQps"), 32)
+	safeQps, err2 := strconv.ParseFloat(r.URL.Query().Get("safeQps"), 32)
+	if err1 != nil || err2 != nil || safeQps <= 0 || maxQps < safeQps {
+		http.Error(w, "invalid maxQps or safeQps, need maxQps >= safeQps > 0", http.StatusBadRequest)
+		return
+	}
+	until, ok := adminUntil(w, r)
+	if !ok {
+		return
+	}
+	ph.adjuster.SetThresholds(service, float32(maxQps), float32(safeQps), until)
+	ph.adminDone(w, r, service, fmt.Sprintf("thresholds set to maxQps=%.2f safeQps=%.2f %s",
+		maxQps, safeQps, untilString(until)))
+}
+
+func (ph *PoolHandler) adminFreeze(w http.ResponseWriter, r *http.Request) {
+	service, ok := ph.adminService(w, r, true)
+	if !ok {
+		return
+	}
+	if r.Method == http.MethodDelete {
+		ph.adjuster.ClearFreezeWindows(service)
+		ph.adminDone(w, r, service, "freeze windows cleared")
+		return
+	}
+	query := r.URL.Query()
+	fw, err := scale.ParseFreezeWindow(query.Get("days"), query.Get("start"), query.Get("end"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ph.adjuster.AddFreezeWindow(service, fw)
+	ph.adminDone(w, r, service, "freeze window added "+fw.String())
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPoolHandler_AdminHandler(t *testing.T) {
+	pool := newTestPool(t)
+	server := httptest.NewServer(pool.AdminHandler("secret"))
+	defer server.Close()
+	do := func(method, path, token string) int {
+		req, err := http.NewRequest(method, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	cases := []struct {
+		method, path, token string
+		want                int
+	}{
+		{http.MethodPost, AdminPath + "pause", "", http.StatusUnauthorized},
+		{http.MethodPost, AdminPath + "pause?duration=30m", "secret", http.StatusOK},
+		{http.MethodGet, AdminPath + "pause", "secret", http.StatusMethodNotAllowed},
+		{http.MethodPost, AdminPath + "pause?service=api.demo", "secret", http.StatusNotFound},
+		{http.MethodPost, AdminPath + "pin?replicas=2", "secret", http.StatusBadRequest},
+		{http.MethodPost, AdminPath + "pin?service=web.demo&replicas=2&duration=1h", "secret", http.StatusOK},
+		{http.MethodPost, AdminPath + "thresholds?service=web.demo&maxQps=5&safeQps=10", "secret", http.StatusBadRequest},
+		{http.MethodPost, AdminPath + "thresholds?service=web.demo&maxQps=30&safeQps=10", "secret", http.StatusOK},
+		{http.MethodPost, AdminPath + "freeze?days=mon,fri&start=22:00&end=06:00", "secret", http.StatusOK},
+		{http.MethodPost, AdminPath + "freeze?start=25:00&end=06:00", "secret", http.StatusBadRequest},
+		{http.MethodPost, AdminPath + "resume?service=web.demo", "secret", http.StatusOK},
+		{http.MethodGet, AdminPath + "overrides", "secret", http.StatusOK},
+	}
+	for _, c := range cases {
+		if got := do(c.method, c.path, c.token); got != c.want {
+			t.Errorf("%s %s = %d, want %d", c.method, c.path, got, c.want)
+		}
+	}
+	overrides := pool.adjuster.Overrides()
+	if o := overrides["web.demo"]; o.Pin != nil || o.MaxQps != 30 {
+		t.Errorf("unexpected web.demo override %+v", o)
+	}
+	if o := overrides["*"]; !o.Paused || len(o.FreezeWindows) != 1 {
+		t.Errorf("unexpected override for all services %+v", o)
+	}
+
+	pool.SetElector(&fakeElector{leader: "10.0.0.1:6060", identity: "10.0.0.2:6060"}, "10.0.0.2:6060")
+	if got := do(http.MethodPost, AdminPath+"pin?service=web.demo&replicas=2", "secret"); got != http.StatusMisdirectedRequest {
+		t.Errorf("follower should reject admin requests, got %d", got)
+	}
+	if o := pool.adjuster.Overrides()["web.demo"]; o.Pin != nil {
+		t.Errorf("follower should not pin, got %+v", o)
+	}
+}
@@ -0,0 +1,213 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"auto-scale/src/ingress"
+)
+
+const (
+	// CountsPath aggregator接收agent上报的接口
+	CountsPath   = "/internal/counts"
+	pushInterval = time.Second
+	// 上报失败时最多保留最近这么多秒的计数,aggregator长时间不可用时不会无限增长
+	pushRetain = 60
+)
+
+// Counts agent每秒上报一次的部分统计,服务名 -> upstream -> unix秒 -> 请求数
+type Counts struct {
+	Agent    string
+	Services map[string]map[string]map[int64]int
+}
+
+// countsReply aggregator返回需要统计的服务,agent据此过滤日志
+type countsReply struct {
+	Services []string
+}
+
+func newAgent(aggregator string) *agent {
+	identity, _ := os.Hostname()
+	return &agent{
+		aggregator: aggregator,
+		identity:   identity,
+		counts:     make(map[string]map[string]map[int64]int),
+		services:   make(map[string]struct{}),
+		httpClient: &http.Client{Timeout: forwardLimit},
+	}
+}
+
+// agent 在ingress节点本地解析日志,只把计数推给aggregator
+type agent struct {
+	mutex      sync.Mutex
+	aggregator string
+	identity   string
+	counts     map[string]map[string]map[int64]int
+	services   map[string]struct{}
+	httpClient *http.Client
+}
+
+func (a *agent) add(access ingress.Access) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	upstreams, ok := a.counts[access.ServiceName()]
+	if !ok {
+		upstreams = make(map[string]map[int64]int)
+		a.counts[access.ServiceName()] = upstreams
+	}
+	seconds, ok := upstreams[access.Upstream()]
+	if !ok {
+		seconds = make(map[int64]int)
+		upstreams[access.Upstream()] = seconds
+	}
+	seconds[access.AccessTime().Unix()]++
+}
+
+// push 上报后清空,aggregator没有接收时放回去下次再上报
+func (a *agent) push() (*countsReply, error) {
+	a.mutex.Lock()
+	counts := &Counts{Agent: a.identity, Services: a.counts}
+	a.counts = make(map[string]map[string]map[int64]int)
+	a.mutex.Unlock()
+	body, err := json.Marshal(counts)
+	if err != nil {
+		a.restore(counts.Services, time.Now())
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s%s", a.aggregator, CountsPath), bytes.NewReader(body))
+	if err != nil {
+		a.restore(counts.Services, time.Now())
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		a.restore(counts.Services, time.Now())
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		a.restore(counts.Services, time.Now())
+		return nil, fmt.Errorf("aggregator %s status %s", a.aggregator, resp.Status)
+	}
+	reply := new(countsReply)
+	if err := json.NewDecoder(resp.Body).Decode(reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// restore 把上报失败的计数合并回去,丢弃超过pushRetain秒的部分
+func (a *agent) restore(services map[string]map[string]map[int64]int, now time.Time) {
+	oldest := now.Unix() - pushRetain
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for service, upstreams := range services {
+		for upstream, seconds := range upstreams {
+			for second, n := range seconds {
+				if second < oldest {
+					continue
+				}
+				current, ok := a.counts[service]
+				if !ok {
+					current = make(map[string]map[int64]int)
+					a.counts[service] = current
+				}
+				if _, ok := current[upstream]; !ok {
+					current[upstream] = make(map[int64]int)
+				}
+				current[upstream][second] += n
+			}
+		}
+	}
+}
+
+// pushCounts agent模式下不在本地伸缩,每秒把计数推给aggregator
+func (ph *PoolHandler) pushCounts() {
+	ticker := time.NewTicker(pushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		reply, err := ph.agent.push()
+		if err != nil {
+			log.Printf("push counts to aggregator error %v", err)
+			continue
+		}
+		ph.syncAgentServices(ph.agent, reply.Services)
+	}
+}
+
+// syncAgentServices 需要统计的服务以aggregator为准
+func (ph *PoolHandler) syncAgentServices(a *agent, services []string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	wants := make(map[string]struct{}, len(services))
+	for _, service := range services {
+		wants[service] = struct{}{}
+		if _, ok := a.services[service]; ok {
+			continue
+		}
+		a.services[service] = struct{}{}
+		for _, worker := range ph.workers {
+			worker.SetScaleService([]string{service})
+		}
+		log.Printf("agent start counting %s", service)
+	}
+	for service := range a.services {
+		if _, ok := wants[service]; ok {
+			continue
+		}
+		delete(a.services, service)
+		for _, worker := range ph.workers {
+			worker.RemoveScaleService(service)
+		}
+		log.Printf("agent stop counting %s", service)
+	}
+}
+
+// ServeCounts aggregator把agent上报的计数合并到各服务的Calculator
+func (ph *PoolHandler) ServeCounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	counts := new(Counts)
+	if err := json.NewDecoder(r.Body).Decode(counts); err != nil {
+		http.Error(w, "bad counts", http.StatusBadRequest)
+		return
+	}
+	ph.mutex.RLock()
+	reply := &countsReply{Services: make([]string, 0, len(ph.counter))}
+	for service := range ph.counter {
+		reply.Services = append(reply.Services, service)
+	}
+	noUpstreams := make([]string, 0)
+	for service, upstreams := range counts.Services {
+		cal, ok := ph.counter[service]
+		if !ok {
+			continue
+		}
+		for upstream, seconds := range upstreams {
+			if upstream == "" || upstream == "-" {
+				noUpstreams = append(noUpstreams, service)
+				continue
+			}
+			for second, n := range seconds {
+				cal.Add(upstream, time.Unix(second, 0), n)
+			}
+		}
+	}
+	ph.mutex.RUnlock()
+	for _, service := range noUpstreams {
+		ph.wake(service)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(reply); err != nil {
+		log.Println(err)
+	}
+}
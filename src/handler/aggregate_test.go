@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"auto-scale/src/ingress"
+	"auto-scale/src/utils"
+)
+
+func TestPoolHandler_ServeCounts(t *testing.T) {
+	aggregator := newTestPool(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc(CountsPath, aggregator.ServeCounts)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &utils.Config{
+		IngressType: "nginx",
+		Default:     &utils.DefaultConfig{AvgTime: 1, ScaleIntervalTime: 60, MaxPod: 2, MinPod: 1, MaxQps: 10, SafeQps: 5},
+		Mode:        utils.ModeAgent,
+		Aggregator:  strings.TrimPrefix(server.URL, "http://"),
+	}
+	agentPool := NewPoolHandler(config, nil)
+	if len(agentPool.counter) != 0 {
+		t.Fatal("agent should not scale")
+	}
+	// 第一次上报拿到需要统计的服务
+	reply, err := agentPool.agent.push()
+	if err != nil {
+		t.Fatal(err)
+	}
+	agentPool.syncAgentServices(agentPool.agent, reply.Services)
+	if _, ok := agentPool.agent.services["web.demo"]; !ok {
+		t.Fatalf("want agent counting web.demo, got %v", reply.Services)
+	}
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		access := &ingress.NGINXAccess{Meta: ingress.Meta{Namespace: "demo", Service: "web"}, Time: now, UpstreamAddr: "10.1.0.1:80"}
+		agentPool.agent.add(access)
+	}
+	agentPool.agent.add(&ingress.NGINXAccess{Meta: ingress.Meta{Namespace: "demo", Service: "web"}, Time: now, UpstreamAddr: "10.1.0.2:80"})
+	if _, err := agentPool.agent.push(); err != nil {
+		t.Fatal(err)
+	}
+	cal := aggregator.counter["web.demo"]
+	cal.mutex.RLock()
+	total := cal.currentCnt + cal.qpsCal.Total()
+	cal.mutex.RUnlock()
+	if total != 4 {
+		t.Errorf("want 4 requests merged, got %d", total)
+	}
+	if upstreams := cal.podCal.Total(); upstreams != 2 {
+		t.Errorf("want 2 upstreams, got %d", upstreams)
+	}
+}
+
+func TestAgent_PushFailed(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`{"Services":["web.demo"]}`))
+	}))
+	defer server.Close()
+	a := newAgent(strings.TrimPrefix(server.URL, "http://"))
+	now := time.Now()
+	a.add(&ingress.NGINXAccess{Meta: ingress.Meta{Namespace: "demo", Service: "web"}, Time: now, UpstreamAddr: "10.1.0.1:80"})
+	// 太久以前的计数不再保留
+	a.counts["web.demo"]["10.1.0.1:80"][now.Unix()-pushRetain-1] = 5
+	if _, err := a.push(); err == nil {
+		t.Fatal("want push error")
+	}
+	seconds := a.counts["web.demo"]["10.1.0.1:80"]
+	if len(seconds) != 1 || seconds[now.Unix()] != 1 {
+		t.Fatalf("want the recent second kept for retry, got %v", seconds)
+	}
+	a.add(&ingress.NGINXAccess{Meta: ingress.Meta{Namespace: "demo", Service: "web"}, Time: now, UpstreamAddr: "10.1.0.1:80"})
+	status = http.StatusOK
+	if _, err := a.push(); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.counts) != 0 {
+		t.Fatalf("want counts cleared after push, got %v", a.counts)
+	}
+}
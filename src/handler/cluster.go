@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// RecordsPath leader接收follower统计结果的接口
+	RecordsPath  = "/internal/records"
+	peerHeader   = "X-Simple-HPA-Peer"
+	forwardLimit = time.Second * 2
+)
+
+// Elector 多副本部署时只有leader执行伸缩,由election.Elector实现
+type Elector interface {
+	IsLeader() bool
+	Leader() string // leader的地址
+}
+
+type peerRecord struct {
+	record *Record
+	in     time.Time
+}
+
+// SetElector 开启多副本,identity为本副本的地址
+func (ph *PoolHandler) SetElector(elector Elector, identity string) {
+	ph.mutex.Lock()
+	defer ph.mutex.Unlock()
+	ph.elector = elector
+	ph.identity = identity
+}
+
+func (ph *PoolHandler) isLeader() bool {
+	ph.mutex.RLock()
+	defer ph.mutex.RUnlock()
+	return ph.elector == nil || ph.elector.IsLeader()
+}
+
+// forward follower把本周期的统计结果发给leader
+func (ph *PoolHandler) forward(record *Record) {
+	ph.mutex.RLock()
+	leader, identity := ph.elector.Leader(), ph.identity
+	ph.mutex.RUnlock()
+	if leader == "" {
+		log.Printf("WARN no leader elected, drop %s record", record.ServiceName)
+		return
+	}
+	body, err := json.Marshal(record)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s%s", leader, RecordsPath), bytes.NewReader(body))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(peerHeader, identity)
+	resp, err := ph.httpClient.Do(req)
+	if err != nil {
+		log.Printf("forward %s record to leader %s error %v", record.ServiceName, leader, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		log.Printf("forward %s record to leader %s error, status %s", record.ServiceName, leader, resp.Status)
+	}
+}
+
+// ServeHTTP 接收follower转发的统计结果,在leader的下一个周期合并
+func (ph *PoolHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	peer := r.Header.Get(peerHeader)
+	record := new(Record)
+	if err := json.NewDecoder(r.Body).Decode(record); err != nil || peer == "" {
+		http.Error(w, "bad record", http.StatusBadRequest)
+		return
+	}
+	ph.mutex.Lock()
+	defer ph.mutex.Unlock()
+	if _, ok := ph.counter[record.ServiceName]; !ok {
+		http.Error(w, record.ServiceName+" is not auto scaled", http.StatusNotFound)
+		return
+	}
+	peers, ok := ph.peers[record.ServiceName]
+	if !ok {
+		peers = make(map[string]*peerRecord)
+		ph.peers[record.ServiceName] = peers
+	}
+	// leader的周期还没到时同一副本转发了多个周期,只保留最新的。
+	// 每个周期的QPS都是按avgTime计算的,累加会把QPS放大
+	peers[peer] = &peerRecord{record: record, in: time.Now()}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// merge 合并其他副本在本周期内转发的统计结果,QPS相加,upstream去重
+func (ph *PoolHandler) merge(record *Record) *Record {
+	ph.mutex.Lock()
+	peers := ph.peers[record.ServiceName]
+	delete(ph.peers, record.ServiceName)
+	ph.mutex.Unlock()
+	if len(peers) == 0 {
+		return record
+	}
+	merged := &Record{
+		ServiceName: record.ServiceName,
+		TotalQps:    record.TotalQps,
+		Upstreams:   record.Upstreams,
+		Counts:      addCounts(nil, record.Counts),
+		Errors:      record.Errors,
+		Timed:       record.Timed,
+		LatencySum:  record.LatencySum,
+	}
+	expire := time.Now().Add(-time.Duration(ph.config.Default.AvgTime) * time.Second * 2)
+	for _, peer := range peers {
+		if peer.in.Before(expire) {
+			continue
+		}
+		merged.TotalQps += peer.record.TotalQps
+		merged.Upstreams = union(merged.Upstreams, peer.record.Upstreams)
+		merged.Counts = addCounts(merged.Counts, peer.record.Counts)
+		merged.Errors += peer.record.Errors
+		merged.Timed += peer.record.Timed
+		merged.LatencySum += peer.record.LatencySum
+	}
+	merged.TotalUpstreams = len(merged.Upstreams)
+	if merged.TotalUpstreams < record.TotalUpstreams {
+		merged.TotalUpstreams = record.TotalUpstreams
+	}
+	return merged
+}
+
+func union(a, b []string) []string {
+	seen := make(map[string]struct{}, len(a)+len(b))
+	result := make([]string, 0, len(a)+len(b))
+	for _, items := range [][]string{a, b} {
+		for _, item := range items {
+			if _, ok := seen[item]; ok {
+				continue
+			}
+			seen[item] = struct{}{}
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func addCounts(a, b map[string]int) map[string]int {
+	if a == nil {
+		a = make(map[string]int, len(b))
+	}
+	for upstream, n := range b {
+		a[upstream] += n
+	}
+	return a
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"auto-scale/src/utils"
+)
+
+type fakeElector struct {
+	leader   string
+	identity string
+}
+
+func (fe *fakeElector) IsLeader() bool {
+	return fe.leader == fe.identity
+}
+
+func (fe *fakeElector) Leader() string {
+	return fe.leader
+}
+
+func newTestPool(t *testing.T) *PoolHandler {
+	config := &utils.Config{
+		IngressType: "nginx",
+		Default:     &utils.DefaultConfig{AvgTime: 1, ScaleIntervalTime: 60, MaxPod: 2, MinPod: 1, MaxQps: 10, SafeQps: 5},
+	}
+	pool := NewPoolHandler(config, &stubScaler{replicas: 1})
+	conf, _ := config.NewAnnotationConfig("demo", "web", "", "", "", map[string]string{utils.AnnotationEnabled: "true"})
+	config.AddDiscoveredService(conf)
+	pool.AddService("web.demo")
+	t.Cleanup(func() { pool.RemoveService("web.demo") })
+	return pool
+}
+
+func TestPoolHandler_Forward(t *testing.T) {
+	leader := newTestPool(t)
+	server := httptest.NewServer(leader)
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+	leader.SetElector(&fakeElector{leader: address, identity: address}, address)
+
+	for _, identity := range []string{"10.0.0.2:6060", "10.0.0.3:6060"} {
+		follower := newTestPool(t)
+		follower.SetElector(&fakeElector{leader: address, identity: identity}, identity)
+		if follower.isLeader() {
+			t.Fatal("want follower")
+		}
+		follower.forward(&Record{ServiceName: "web.demo", TotalQps: 30, TotalUpstreams: 2, Upstreams: []string{"a", "b"}})
+	}
+	// 同一个副本在leader的一个周期内转发两次
+	follower := newTestPool(t)
+	follower.SetElector(&fakeElector{leader: address, identity: "10.0.0.2:6060"}, "10.0.0.2:6060")
+	follower.forward(&Record{ServiceName: "web.demo", TotalQps: 10, TotalUpstreams: 1, Upstreams: []string{"c"}})
+
+	if !leader.isLeader() {
+		t.Fatal("want leader")
+	}
+	merged := leader.merge(&Record{ServiceName: "web.demo", TotalQps: 20, TotalUpstreams: 2, Upstreams: []string{"a", "b"}})
+	// 10.0.0.2只使用最新的一次: 20+10+30
+	if merged.TotalQps != 60 || merged.TotalUpstreams != 3 {
+		t.Errorf("want qps 60 and 3 upstreams, got %+v", merged)
+	}
+	if merged = leader.merge(&Record{ServiceName: "web.demo", TotalQps: 20, TotalUpstreams: 2}); merged.TotalQps != 20 {
+		t.Errorf("peer records should be consumed, got %+v", merged)
+	}
+}
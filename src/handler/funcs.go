@@ -76,6 +76,7 @@ func newRingBuffer(max uint, duration time.Duration) *RingBuffer {
 		cap:   max,
 		data:  make([]int, max),
 		mutex: sync.RWMutex{},
+		stop:  make(chan struct{}),
 	}
 	go r.expire(duration)
 	return r
@@ -88,6 +89,7 @@ type RingBuffer struct {
 	in    uint  // 写入索引位置
 	out   uint  // 读取索引位置
 	data  []int // 内部最终数据
+	stop  chan struct{}
 }
 
 func (rb *RingBuffer) expire(duration time.Duration) {
@@ -101,10 +103,17 @@ func (rb *RingBuffer) expire(duration time.Duration) {
 				rb.size--
 			}
 			rb.mutex.RUnlock()
+		case <-rb.stop:
+			ticker.Stop()
+			return
 		}
 	}
 }
 
+func (rb *RingBuffer) Close() {
+	close(rb.stop)
+}
+
 func (rb *RingBuffer) isFull() bool {
 	return rb.cap == rb.size
 }
@@ -137,7 +146,7 @@ func (rb *RingBuffer) Total() int {
 }
 
 func newUpstream(expire time.Duration) *UpStream {
-	v := &UpStream{mutex: sync.Mutex{}, duration: expire, backends: make(map[string]time.Time)}
+	v := &UpStream{mutex: sync.Mutex{}, duration: expire, backends: make(map[string]time.Time), stop: make(chan struct{})}
 	go v.expire()
 	return v
 }
@@ -146,6 +155,7 @@ type UpStream struct {
 	mutex    sync.Mutex
 	duration time.Duration
 	backends map[string]time.Time
+	stop     chan struct{}
 }
 
 func (us *UpStream) expire() {
@@ -160,14 +170,31 @@ func (us *UpStream) expire() {
 				}
 			}
 			us.mutex.Unlock()
+		case <-us.stop:
+			ticker.Stop()
+			return
 		}
 	}
 }
 
+func (us *UpStream) Close() {
+	close(us.stop)
+}
+
 func (us *UpStream) Total() int {
 	return len(us.backends)
 }
 
+func (us *UpStream) Backends() []string {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+	backends := make([]string, 0, len(us.backends))
+	for backend := range us.backends {
+		backends = append(backends, backend)
+	}
+	return backends
+}
+
 func (us *UpStream) Update(upstream string, accessTime time.Time) {
 	us.mutex.Lock()
 	defer us.mutex.Unlock()
@@ -180,6 +207,11 @@ type Record struct {
 	ServiceName    string
 	TotalQps       int
 	TotalUpstreams int
+	Upstreams      []string       // 多副本合并时按upstream去重
+	Counts         map[string]int // 每个upstream的请求数,用于排除预热中的Pod
+	Errors         int            // 本周期5xx的请求数
+	Timed          int            // 本周期有upstream响应时间的请求数,agent上报的计数没有
+	LatencySum     float64        // 本周期upstream响应时间之和,单位秒
 }
 
 func (r *Record) AvgQps() float32 {
@@ -189,6 +221,49 @@ func (r *Record) AvgQps() float32 {
 	return float32(r.TotalQps) / float32(r.TotalUpstreams)
 }
 
+// AvgQpsWithout 去掉excluded中的upstream及其请求后的平均值,全部被去掉时同AvgQps
+func (r *Record) AvgQpsWithout(excluded map[string]bool) float32 {
+	totalQps, totalUpstreams := r.TotalQps, r.TotalUpstreams
+	for upstream := range excluded {
+		totalQps -= r.Counts[upstream]
+		totalUpstreams--
+	}
+	if len(excluded) == 0 || totalQps < 0 || totalUpstreams <= 0 {
+		return r.AvgQps()
+	}
+	return float32(totalQps) / float32(totalUpstreams)
+}
+
+// ErrorRate 本周期5xx的比例
+func (r *Record) ErrorRate() float64 {
+	if r.Timed == 0 {
+		return 0
+	}
+	return float64(r.Errors) / float64(r.Timed)
+}
+
+// AvgLatency 本周期upstream的平均响应时间,单位秒
+func (r *Record) AvgLatency() float64 {
+	if r.Timed == 0 {
+		return 0
+	}
+	return r.LatencySum / float64(r.Timed)
+}
+
+// Load 每个upstream的负载,本周期的请求数。最近有请求但本周期没有的记为1,可能还在处理慢请求
+func (r *Record) Load() map[string]int {
+	load := make(map[string]int, len(r.Upstreams))
+	for _, upstream := range r.Upstreams {
+		load[upstream] = 1
+	}
+	for upstream, n := range r.Counts {
+		if n > 0 {
+			load[upstream] = n
+		}
+	}
+	return load
+}
+
 func NewCalculator(svcName string, frequency int) *Calculator {
 	duration := time.Duration(frequency) * time.Second
 	r := &Calculator{
@@ -199,7 +274,9 @@ func NewCalculator(svcName string, frequency int) *Calculator {
 		currentCnt: 0,
 		secTicker:  time.NewTicker(time.Second),
 		resultChan: make(chan *Record, frequency),
+		counts:     make(map[string]int),
 		serviceName: svcName,
+		stop:       make(chan struct{}),
 	}
 	go r.inPipe()
 	return r
@@ -213,24 +290,44 @@ type Calculator struct {
 	currentCnt int                    // 一秒之内的数据，一秒后会加入到data里面
 	secTicker  *time.Ticker           // 重置时钟
 	resultChan chan *Record           // 计算出结果后的
+	counts     map[string]int         // 本周期每个upstream的请求数
+	errors     int                    // 本周期5xx的请求数
+	timed      int                    // 本周期有响应时间的请求数
+	latencySum float64                // 本周期响应时间之和,单位秒
 	// inTicker    *time.Ticker
 	serviceName string
+	stop        chan struct{}
 }
 
 func (c *Calculator) Update(v ingress.Access) {
-	if v.AccessTime().Add(c.duration).Before(time.Now()) {
+	c.Add(v.Upstream(), v.AccessTime(), 1)
+	if latency, ok := v.ResponseTime(); ok && !v.AccessTime().Add(c.duration).Before(time.Now()) {
+		c.mutex.Lock()
+		c.timed++
+		c.latencySum += latency.Seconds()
+		if v.StatusCode() >= 500 {
+			c.errors++
+		}
+		c.mutex.Unlock()
+	}
+}
+
+// Add 累加n个请求,agent上报的按秒汇总的结果也通过它加入
+func (c *Calculator) Add(upstream string, accessTime time.Time, n int) {
+	if accessTime.Add(c.duration).Before(time.Now()) {
 		return
 	}
 	c.mutex.Lock()
-	c.currentCnt++
+	c.currentCnt += n
+	c.counts[upstream] += n
 	c.mutex.Unlock()
 	select {
 	case <-c.secTicker.C:
 		c.qpsCal.Insert(c.currentCnt)
-		c.currentCnt = 1
+		c.currentCnt = n
 	default:
 	}
-	c.podCal.Update(v.Upstream(), v.AccessTime())
+	c.podCal.Update(upstream, accessTime)
 }
 
 func (c *Calculator) inPipe() {
@@ -238,14 +335,36 @@ func (c *Calculator) inPipe() {
 	for {
 		select {
 		case <-ticker.C:
+			c.mutex.Lock()
+			counts := c.counts
+			c.counts = make(map[string]int)
+			errors, timed, latencySum := c.errors, c.timed, c.latencySum
+			c.errors, c.timed, c.latencySum = 0, 0, 0
+			c.mutex.Unlock()
 			c.resultChan <- &Record{ServiceName: c.serviceName,
 				TotalQps:       c.qpsCal.Total() + c.currentCnt,
 				TotalUpstreams: c.podCal.Total(),
+				Upstreams:      c.podCal.Backends(),
+				Counts:         counts,
+				Errors:         errors,
+				Timed:          timed,
+				LatencySum:     latencySum,
 			}
+		case <-c.stop:
+			ticker.Stop()
+			close(c.resultChan)
+			return
 		}
 	}
 }
 
+// Stop 服务不再需要伸缩时停止计算,Pipeline会被关闭
+func (c *Calculator) Stop() {
+	close(c.stop)
+	c.qpsCal.Close()
+	c.podCal.Close()
+}
+
 func (c *Calculator) Pipeline() <-chan *Record {
 	return c.resultChan
 }
\ No newline at end of file
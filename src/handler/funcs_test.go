@@ -34,3 +34,33 @@ func TestNewRingBuffer(t *testing.T) {
         log.Println(key)
     }
 }
+
+func TestRecord_AvgQpsWithout(t *testing.T) {
+    record := &Record{
+        ServiceName:    "web.demo",
+        TotalQps:       100,
+        TotalUpstreams: 3,
+        Upstreams:      []string{"a", "b", "c"},
+        Counts:         map[string]int{"a": 45, "b": 45, "c": 10},
+    }
+    if qps := record.AvgQpsWithout(map[string]bool{"c": true}); qps != 45 {
+        t.Errorf("want 45, got %.2f", qps)
+    }
+    if qps := record.AvgQpsWithout(map[string]bool{"a": true, "b": true, "c": true}); qps != record.AvgQps() {
+        t.Errorf("all warming should use AvgQps, got %.2f", qps)
+    }
+}
+
+func TestRecord_Load(t *testing.T) {
+    record := &Record{Upstreams: []string{"a", "b"}, Counts: map[string]int{"a": 5}}
+    if load := record.Load(); load["a"] != 5 || load["b"] != 1 {
+        t.Errorf("want a=5 b=1, got %v", load)
+    }
+}
+
+func TestRecord_ErrorRate(t *testing.T) {
+    record := &Record{Errors: 5, Timed: 50, LatencySum: 10}
+    if rate, latency := record.ErrorRate(), record.AvgLatency(); rate != 0.1 || latency != 0.2 {
+        t.Errorf("want 0.1 and 0.2s, got %.2f %.2fs", rate, latency)
+    }
+}
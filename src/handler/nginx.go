@@ -3,6 +3,7 @@ package handler
 import (
 	"bytes"
 	"log"
+	"sync"
 
 	"auto-scale/src/ingress"
 )
@@ -12,15 +13,24 @@ type nginxDataHandler struct {
 	// 日志切割关键字
 	logKey      []byte
 	ingressType IngressType
+	mutex       sync.RWMutex
 	autoService map[string]struct{}
 }
 
 func (ndh *nginxDataHandler) SetScaleService(services []string) {
+	ndh.mutex.Lock()
+	defer ndh.mutex.Unlock()
 	for _, service := range services {
 		ndh.autoService[service] = struct{}{}
 	}
 }
 
+func (ndh *nginxDataHandler) RemoveScaleService(service string) {
+	ndh.mutex.Lock()
+	defer ndh.mutex.Unlock()
+	delete(ndh.autoService, service)
+}
+
 func (ndh *nginxDataHandler) ParseData(data []byte) ingress.Access {
 	byteStrings := bytes.Split(data, ndh.logKey)
 	if len(byteStrings) != 2 {
@@ -35,7 +45,10 @@ func (ndh *nginxDataHandler) ParseData(data []byte) ingress.Access {
 		log.Println("json failed", err)
 		return nil
 	}
-	if _, ok := ndh.autoService[accessItem.ServiceName()]; ok {
+	ndh.mutex.RLock()
+	_, ok := ndh.autoService[accessItem.ServiceName()]
+	ndh.mutex.RUnlock()
+	if ok {
 		return accessItem
 	}
 	return nil
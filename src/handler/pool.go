@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"auto-scale/src/ingress"
@@ -25,6 +28,7 @@ const (
 type handler interface {
 	ParseData(data []byte) ingress.Access
 	SetScaleService(services []string)
+	RemoveScaleService(service string)
 }
 
 func newDataHandler(ingressType IngressType) handler {
@@ -41,7 +45,7 @@ func newDataHandler(ingressType IngressType) handler {
 	return nil
 }
 
-func NewPoolHandler(config *utils.Config) *PoolHandler {
+func NewPoolHandler(config *utils.Config, client scale.Scaler) *PoolHandler {
 	var ingressType IngressType
 	switch config.IngressType {
 	case "nginx":
@@ -69,11 +73,40 @@ func NewPoolHandler(config *utils.Config) *PoolHandler {
 		config:     config,
 		workers:    workers,
 		senders:    senders,
-		adjuster:   scale.NewScaler(minuteCount/config.Default.AvgTime, config.Default.ScaleIntervalTime),
+		adjuster:   scale.NewScaler(minuteCount/config.Default.AvgTime, config.Default.ScaleIntervalTime, client),
 		poolSize:   defaultPoolSize,
 		queue:      queues,
 		counter:    make(map[string]*Calculator),
+		peers:      make(map[string]map[string]*peerRecord),
+		lastSeen:   make(map[string]time.Time),
+		wakes:      make(map[string]time.Time),
+		httpClient: &http.Client{Timeout: forwardLimit},
 	}
+	if config.Mode == utils.ModeAgent {
+		poolHandler.agent = newAgent(config.Aggregator)
+	}
+	poolHandler.adjuster.SetNotify(poolHandler.notify)
+	if budget := config.Budget; budget.MaxPods > 0 || budget.MaxCPU > 0 || len(budget.Namespaces) > 0 {
+		namespaces := make(map[string]scale.BudgetLimit, len(budget.Namespaces))
+		for ns, limit := range budget.Namespaces {
+			namespaces[ns] = scale.BudgetLimit{MaxPods: limit.MaxPods, MaxCPU: limit.MaxCPU}
+		}
+		poolHandler.adjuster.SetBudget(&scale.Budget{
+			BudgetLimit: scale.BudgetLimit{MaxPods: budget.MaxPods, MaxCPU: budget.MaxCPU},
+			Namespaces:  namespaces,
+		})
+	}
+	poolHandler.adjuster.SetDeletionCost(config.Default.DeletionCost)
+	if regression := config.Regression; regression.Window > 0 {
+		poolHandler.adjuster.SetRegressionPolicy(&scale.RegressionPolicy{
+			Window:       time.Duration(regression.Window) * time.Second,
+			ErrorRate:    regression.ErrorRate,
+			LatencyRatio: regression.LatencyRatio,
+			Suppress:     time.Duration(regression.Suppress) * time.Second,
+		})
+	}
+	poolHandler.adjuster.SetManualPolicy(config.Default.ManualChange,
+		time.Duration(config.Default.ManualGrace)*time.Second, poolHandler.isLeader)
 	poolHandler.startWorkers()
 	return poolHandler
 }
@@ -82,7 +115,16 @@ type PoolHandler struct {
 	config     *utils.Config
 	senders    []utils.Sender
 	workers    []handler
+	mutex      sync.RWMutex
 	counter    map[string]*Calculator
+	observers  []Observer
+	elector    Elector
+	identity   string
+	peers      map[string]map[string]*peerRecord // 服务名 -> 副本地址 -> 最近转发的结果
+	httpClient *http.Client
+	agent      *agent // agent模式下不为空
+	lastSeen   map[string]time.Time // 服务最近一次有请求的时间
+	wakes      map[string]time.Time // 服务最近一次尝试从0扩容的时间
 	adjuster   *scale.ScalerManage
 	poolSize   uint8
 	queue      []chan []byte
@@ -94,49 +136,257 @@ func (ph *PoolHandler) Execute(data []byte) {
 	ph.queue[index] <- data
 }
 
-func (ph *PoolHandler) autoScale() {
-	for key, cal := range ph.counter {
-		log.Printf("start %s auto scale worker success", key)
-		go func(cal *Calculator) {
-			for {
-				record := <-cal.Pipeline()
-				if record == nil {
-					continue
+func (ph *PoolHandler) autoScale(cal *Calculator) {
+	for record := range cal.Pipeline() {
+		conf := ph.config.GetServiceConfig(record.ServiceName)
+		if conf == nil {
+			continue
+		}
+		if !ph.isLeader() {
+			ph.forward(record)
+			continue
+		}
+		record = ph.merge(record)
+		if record.TotalQps > 0 {
+			ph.seen(record.ServiceName)
+		}
+		// 预热中的Pod不计入平均QPS
+		warming := ph.adjuster.WarmingUp(record.ServiceName, record.Upstreams, time.Duration(conf.WarmUp)*time.Second)
+		qps := record.AvgQpsWithout(warming) * conf.Factor / float32(ph.config.Default.AvgTime)
+		log.Printf("latest %d seconds %s qps(*%.1f)=%.1f active upstreams=%d warming up=%d",
+			ph.config.Default.AvgTime,
+			record.ServiceName,
+			conf.Factor,
+			qps,
+			record.TotalUpstreams,
+			len(warming),
+		)
+		if ph.checkRegression(record, qps) {
+			continue
+		}
+		// 管理接口可以临时修改阈值
+		maxQps, safeQps := ph.adjuster.Thresholds(record.ServiceName, conf.MaxQps, conf.SafeQps)
+		// 设置了CPU、内存目标利用率时取两者中较大的副本数,利用率过高时即使QPS不高也扩容
+		wants, rec := ph.adjuster.Recommend(record.ServiceName, int32(math.Ceil(float64(qps/maxQps))))
+		isSafe, isWaste := qps < maxQps, qps < safeQps
+		if rec != nil {
+			log.Printf("%s resources %s, wants %d by resources", record.ServiceName, rec.Reason, rec.Desired)
+			isSafe = isSafe && rec.Desired <= rec.Current
+			isWaste = isWaste && rec.Desired < rec.Current
+		}
+		ph.adjuster.Update(record.ServiceName, isSafe, isWaste)
+		cnt := wants
+		if cnt > conf.MaxPod {
+			cnt = conf.MaxPod
+		}
+		if cnt < conf.MinPod {
+			cnt = conf.MinPod
+		}
+		least := conf.MinPod
+		if conf.MinActivePod > 0 && !ph.idle(record.ServiceName, conf.IdleTime) && least < conf.MinActivePod {
+			// 空闲够久才允许缩到minActivePod以下
+			least = conf.MinActivePod
+		}
+		if floor := ph.adjuster.Floor(record.ServiceName); least < floor {
+			// 人工修改后的副本数作为下限
+			least = floor
+		}
+		if cnt < least {
+			cnt = least
+		}
+		// 超过全局或namespace的预算时按优先级分配,下限总是保留
+		cnt = ph.adjuster.Allocate(record.ServiceName, conf.Namespace, conf.Priority, least, cnt)
+		observation := &Observation{ServiceName: record.ServiceName, Qps: qps, Desired: cnt}
+		if ph.adjuster.NeedChange(record.ServiceName) {
+			if wants > conf.MaxPod {
+				log.Printf("%s wants %d, but max is %d", record.ServiceName, wants, conf.MaxPod)
+			}
+			reason := &scale.Reason{Qps: qps, MaxQps: maxQps, SafeQps: safeQps, Load: record.Load()}
+			change, err := ph.adjuster.ChangeServicePod(record.ServiceName, &cnt, reason)
+			msgs := make([]string, 0)
+			if err != nil {
+				log.Println(err)
+				msgs = append(msgs, fmt.Sprintf("%s change to %d failed: %v", record.ServiceName, cnt, err))
+			} else {
+				if change != nil {
+					msgs = append(msgs, change.String())
+					cnt = change.New
 				}
-				conf := ph.config.GetServiceConfig(record.ServiceName)
-				if conf == nil {
-					continue
+				// 分组中的followers在同一周期按leader的副本数伸缩,一起通知
+				followers, err := ph.adjuster.ChangeFollowers(record.ServiceName, cnt, reason)
+				for _, fc := range followers {
+					msgs = append(msgs, fc.String())
 				}
-				qps := record.AvgQps() * conf.Factor / float32(ph.config.Default.AvgTime)
-				log.Printf("latest %d seconds %s qps(*%.1f)=%.1f active upstreams=%d",
-					ph.config.Default.AvgTime,
-					record.ServiceName,
-					conf.Factor,
-					qps,
-					record.TotalUpstreams,
-				)
-				ph.adjuster.Update(record.ServiceName, qps < conf.MaxQps, qps < conf.SafeQps)
-				if ph.adjuster.NeedChange(record.ServiceName) {
-					cnt := int32(math.Ceil(float64(qps / conf.MaxQps)))
-					if cnt > conf.MaxPod {
-						log.Printf("%s wants %d, but max is %d", record.ServiceName, cnt, conf.MaxPod)
-						cnt = conf.MaxPod
-					}
-					if cnt < conf.MinPod {
-						cnt = conf.MinPod
-					}
-					oldCnt := ph.adjuster.ChangeServicePod(record.ServiceName, &cnt)
-					if oldCnt != nil {
-						go func() {
-							msg := fmt.Sprintf("%s from %d to %d", record.ServiceName, *oldCnt, cnt)
-							for _, sender := range ph.senders {
-								sender.Send(msg)
-							}
-						}()
-					}
+				if err != nil {
+					log.Println(err)
+					msgs = append(msgs, err.Error())
 				}
 			}
-		}(cal)
+			if len(msgs) > 0 {
+				ph.notify(strings.Join(msgs, "\n"))
+			}
+			observation.Change, observation.Err = change, err
+		}
+		ph.observe(observation)
+	}
+	log.Printf("stop %s auto scale worker", cal.serviceName)
+}
+
+// Observation 每个采样周期的计算结果,Change和Err只在本周期尝试伸缩时有值
+type Observation struct {
+	ServiceName string
+	Qps         float32
+	Desired     int32
+	Change      *scale.Change
+	Err         error
+}
+
+type Observer interface {
+	Observe(observation *Observation)
+}
+
+func (ph *PoolHandler) AddObserver(observer Observer) {
+	ph.mutex.Lock()
+	defer ph.mutex.Unlock()
+	ph.observers = append(ph.observers, observer)
+}
+
+func (ph *PoolHandler) observe(observation *Observation) {
+	ph.mutex.RLock()
+	defer ph.mutex.RUnlock()
+	for _, observer := range ph.observers {
+		observer.Observe(observation)
+	}
+}
+
+// Replicas 服务当前的副本总数,试运行时为模拟的副本数
+func (ph *PoolHandler) Replicas(serviceName string) (int32, error) {
+	return ph.adjuster.Replicas(serviceName)
+}
+
+func (ph *PoolHandler) notify(msg string) {
+	go func() {
+		for _, sender := range ph.senders {
+			sender.Send(msg)
+		}
+	}()
+}
+
+// AddService 开始统计服务的QPS并自动伸缩,服务的配置需要已经在config中。
+// 已经存在时只更新伸缩目标等设置
+func (ph *PoolHandler) AddService(serviceName string) {
+	conf := ph.config.GetServiceConfig(serviceName)
+	if conf == nil {
+		log.Printf("WARN %s has no config, skip it", serviceName)
+		return
+	}
+	targets := make([]*scale.Target, len(conf.TargetRefs))
+	for i, ref := range conf.TargetRefs {
+		targets[i] = scale.NewTarget(ref.APIVersion, ref.Kind, conf.Namespace, ref.Name)
+		targets[i].Weight = ref.Weight
+	}
+	var backend scale.Scaler
+	if b := conf.Backend; b != nil {
+		switch b.Type {
+		case utils.BackendExec:
+			es, err := scale.NewExecScaler(b.Get, b.Set)
+			if err != nil {
+				log.Printf("WARN %s exec backend error %v, skip it", serviceName, err)
+				return
+			}
+			backend = es
+		case utils.BackendWebhook:
+			backend = scale.NewWebhookScaler(b.URL, b.Token)
+		}
+	} else if conf.Cluster != "" {
+		kc, err := scale.ClusterClient(conf.Cluster)
+		if err != nil {
+			log.Printf("WARN %s %v, skip it", serviceName, err)
+			return
+		}
+		backend = kc
+	}
+	ph.adjuster.SetBackend(serviceName, backend)
+	ph.adjuster.SetTargets(serviceName, targets)
+	ph.adjuster.SetDryRun(serviceName, *conf.DryRun)
+	ph.adjuster.SetResourceTargets(serviceName, scale.ResourceTargets{
+		CPU:    conf.TargetCPUUtilization,
+		Memory: conf.TargetMemoryUtilization,
+	})
+	followers := ph.setGroup(serviceName, backend, *conf.DryRun)
+	if ph.isStart {
+		// 新增或修改的服务重新检查权限,启动时配置中的服务由main统一检查
+		go ph.Preflight(serviceName)
+	}
+	ph.mutex.Lock()
+	if _, ok := ph.counter[serviceName]; ok {
+		ph.mutex.Unlock()
+		return
+	}
+	cal := NewCalculator(serviceName, ph.config.Default.AvgTime)
+	ph.counter[serviceName] = cal
+	ph.lastSeen[serviceName] = time.Now()
+	ph.mutex.Unlock()
+	for _, worker := range ph.workers {
+		worker.SetScaleService([]string{serviceName})
+	}
+	log.Printf("start %s auto scale worker success", serviceName)
+	go ph.adjuster.Watch(serviceName)
+	for _, follower := range followers {
+		go ph.adjuster.Watch(follower)
+	}
+	go ph.autoScale(cal)
+}
+
+// setGroup 服务为分组的leader时,followers使用leader的后端和试运行设置,返回followers
+func (ph *PoolHandler) setGroup(serviceName string, backend scale.Scaler, dryRun bool) []string {
+	conf := ph.config.GetGroupConfig(serviceName)
+	if conf == nil {
+		ph.adjuster.SetGroup(serviceName, nil)
+		return nil
+	}
+	group := &scale.Group{Name: conf.Name, Leader: serviceName, Followers: make([]*scale.Follower, len(conf.Followers))}
+	names := make([]string, len(conf.Followers))
+	for i, fc := range conf.Followers {
+		follower := &scale.Follower{ServiceName: fc.Service, Ratio: fc.Ratio, MinPod: fc.MinPod, MaxPod: fc.MaxPod}
+		if fc.Formula != "" {
+			formula, err := scale.ParseFormula(fc.Formula)
+			if err != nil {
+				log.Printf("WARN group %s %v, skip the group", conf.Name, err)
+				ph.adjuster.SetGroup(serviceName, nil)
+				return nil
+			}
+			follower.Formula = formula
+		}
+		ph.adjuster.SetBackend(fc.Service, backend)
+		ph.adjuster.SetDryRun(fc.Service, dryRun)
+		group.Followers[i], names[i] = follower, fc.Service
+	}
+	ph.adjuster.SetGroup(serviceName, group)
+	return names
+}
+
+// RemoveService 停止统计服务的QPS,并清理伸缩状态
+func (ph *PoolHandler) RemoveService(serviceName string) {
+	ph.mutex.Lock()
+	cal, ok := ph.counter[serviceName]
+	delete(ph.counter, serviceName)
+	delete(ph.peers, serviceName)
+	delete(ph.lastSeen, serviceName)
+	delete(ph.wakes, serviceName)
+	ph.mutex.Unlock()
+	if !ok {
+		return
+	}
+	for _, worker := range ph.workers {
+		worker.RemoveScaleService(serviceName)
+	}
+	cal.Stop()
+	ph.adjuster.RemoveService(serviceName)
+	if group := ph.config.GetGroupConfig(serviceName); group != nil {
+		for _, follower := range group.Followers {
+			ph.adjuster.RemoveService(follower.Service)
+		}
 	}
 }
 
@@ -144,14 +394,15 @@ func (ph *PoolHandler) startWorkers() {
 	if ph.isStart {
 		return
 	}
-	for i, worker := range ph.workers {
-		services := make([]string, len(ph.config.ScaleServices))
-		for j, config := range ph.config.ScaleServices {
-			fullName := fmt.Sprintf("%s.%s", config.ServiceName, config.Namespace)
-			services[j] = fullName
-			ph.counter[fullName] = NewCalculator(fullName, ph.config.Default.AvgTime)
+	if ph.agent != nil {
+		log.Printf("agent mode, push counts to aggregator %s", ph.agent.aggregator)
+		go ph.pushCounts()
+	} else {
+		for _, config := range ph.config.ScaleServices {
+			ph.AddService(fmt.Sprintf("%s.%s", config.ServiceName, config.Namespace))
 		}
-		worker.SetScaleService(services)
+	}
+	for i, worker := range ph.workers {
 		go func(i int, worker handler) {
 			for {
 				byteData := <-ph.queue[i]
@@ -159,10 +410,21 @@ func (ph *PoolHandler) startWorkers() {
 				if accessItem == nil {
 					continue
 				}
-				ph.counter[accessItem.ServiceName()].Update(accessItem)
+				if ph.agent != nil {
+					ph.agent.add(accessItem)
+					continue
+				}
+				if accessItem.NoUpstream() && ph.wake(accessItem.ServiceName()) {
+					continue
+				}
+				ph.mutex.RLock()
+				cal, ok := ph.counter[accessItem.ServiceName()]
+				ph.mutex.RUnlock()
+				if ok {
+					cal.Update(accessItem)
+				}
 			}
 		}(i, worker)
 	}
-	go ph.autoScale()
 	ph.isStart = true
 }
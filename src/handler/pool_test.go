@@ -3,6 +3,7 @@ package handler
 import (
     "auto-scale/src/scale"
     "auto-scale/src/utils"
+    "sync"
     "testing"
     "time"
 )
@@ -14,3 +15,57 @@ func TestNewPoolHandler(t *testing.T) {
     pool.Execute([]byte("hello,world"))
     time.Sleep(time.Second * 5)
 }
+
+// stubScaler 包内测试共用,所有工作负载共用一个副本数
+type stubScaler struct {
+    mutex    sync.Mutex
+    replicas int32
+}
+
+func (s *stubScaler) GetServicePod(target *scale.Target) (*int32, error) {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    cnt := s.replicas
+    return &cnt, nil
+}
+
+func (s *stubScaler) ChangeServicePod(target *scale.Target, newCount *int32) error {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    s.replicas = *newCount
+    return nil
+}
+
+func (s *stubScaler) get() int32 {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    return s.replicas
+}
+
+func TestPoolHandler_AddService(t *testing.T) {
+    config := &utils.Config{
+        IngressType: "nginx",
+        Default:     &utils.DefaultConfig{AvgTime: 1, ScaleIntervalTime: 60, MaxPod: 2, MinPod: 1, MaxQps: 10, SafeQps: 5},
+    }
+    pool := NewPoolHandler(config, &stubScaler{replicas: 1})
+    conf, _ := config.NewAnnotationConfig("demo", "web", "", "", "", map[string]string{utils.AnnotationEnabled: "true"})
+    config.AddDiscoveredService(conf)
+    pool.AddService("web.demo")
+    pool.AddService("web.demo")
+    cal := pool.counter["web.demo"]
+    if cal == nil || len(pool.counter) != 1 {
+        t.Fatalf("want one calculator, got %v", pool.counter)
+    }
+    pool.RemoveService("web.demo")
+    if _, ok := pool.counter["web.demo"]; ok {
+        t.Fatal("want web.demo removed")
+    }
+    select {
+    case _, ok := <-cal.Pipeline():
+        if ok {
+            t.Fatal("want pipeline closed")
+        }
+    case <-time.After(time.Second * 3):
+        t.Fatal("pipeline not closed")
+    }
+}
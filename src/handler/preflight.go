@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"auto-scale/src/scale"
+)
+
+// Preflight 检查服务伸缩需要的RBAC权限,缺少时记录、通知并打印需要补充的ClusterRole rules。
+// 都有权限时返回true,检查出错的服务不算缺少权限
+func (ph *PoolHandler) Preflight(serviceNames ...string) bool {
+	missing := make([]scale.Permission, 0)
+	degraded := make([]string, 0)
+	for _, serviceName := range serviceNames {
+		permissions, err := ph.adjuster.Preflight(serviceName)
+		if err != nil {
+			log.Printf("WARN %s preflight error %v", serviceName, err)
+			continue
+		}
+		if len(permissions) > 0 {
+			missing = append(missing, permissions...)
+			degraded = append(degraded, serviceName)
+		}
+	}
+	if len(missing) == 0 {
+		return true
+	}
+	log.Printf("WARN missing permissions for %s, add to the ClusterRole:\n%s",
+		strings.Join(degraded, ","), scale.ClusterRoleRules(missing))
+	ph.notify(fmt.Sprintf("%s degraded, missing RBAC permissions, see simple-hpa logs", strings.Join(degraded, ",")))
+	return false
+}
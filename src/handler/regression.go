@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+
+	"auto-scale/src/scale"
+)
+
+// checkRegression 缩容后错误率或延迟变差时恢复副本数,恢复或恢复失败时返回true,本周期不再判断
+func (ph *PoolHandler) checkRegression(record *Record, qps float32) bool {
+	signal := scale.Signal{Requests: record.Timed, ErrorRate: record.ErrorRate(), Latency: record.AvgLatency()}
+	change, err := ph.adjuster.CheckRegression(record.ServiceName, signal)
+	if change == nil && err == nil {
+		return false
+	}
+	observation := &Observation{ServiceName: record.ServiceName, Qps: qps, Change: change, Err: err}
+	if err != nil {
+		log.Println(err)
+		ph.notify(fmt.Sprintf("%s roll back scale-down failed: %v", record.ServiceName, err))
+	} else {
+		observation.Desired = change.New
+		ph.notify(fmt.Sprintf("%s, %s", change, change.Reason))
+	}
+	ph.observe(observation)
+	return true
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// 同一服务在wakeInterval内只尝试一次从0扩容,避免503日志反复触发
+const wakeInterval = time.Second * 30
+
+func (ph *PoolHandler) seen(serviceName string) {
+	ph.mutex.Lock()
+	defer ph.mutex.Unlock()
+	ph.lastSeen[serviceName] = time.Now()
+}
+
+// idle 超过idleTime秒没有任何请求
+func (ph *PoolHandler) idle(serviceName string, idleTime int) bool {
+	ph.mutex.RLock()
+	defer ph.mutex.RUnlock()
+	last, ok := ph.lastSeen[serviceName]
+	return ok && time.Since(last) > time.Duration(idleTime)*time.Second
+}
+
+// wake 服务没有可用后端时的请求作为唤醒信号,副本数为0时立即扩到minActivePod。
+// 服务没有开启缩到0时返回false,按普通请求统计
+func (ph *PoolHandler) wake(serviceName string) bool {
+	conf := ph.config.GetServiceConfig(serviceName)
+	if conf == nil || conf.MinActivePod <= 0 {
+		return false
+	}
+	now := time.Now()
+	ph.mutex.Lock()
+	ph.lastSeen[serviceName] = now
+	if last, ok := ph.wakes[serviceName]; ok && now.Sub(last) < wakeInterval {
+		ph.mutex.Unlock()
+		return true
+	}
+	ph.wakes[serviceName] = now
+	ph.mutex.Unlock()
+	go func() {
+		cnt := conf.MinActivePod
+		change, err := ph.adjuster.Wake(serviceName, cnt)
+		if err != nil {
+			log.Println(err)
+			ph.notify(fmt.Sprintf("%s wake up to %d failed: %v", serviceName, cnt, err))
+		} else if change != nil {
+			ph.notify(change.String())
+		}
+		if change != nil || err != nil {
+			ph.observe(&Observation{ServiceName: serviceName, Desired: cnt, Change: change, Err: err})
+		}
+	}()
+	return true
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"auto-scale/src/utils"
+)
+
+const wakeConfig = `
+ingressType: nginx
+default:
+  avgTime: 1
+  scaleIntervalTime: 60
+  maxPod: 4
+  minPod: 1
+  maxQps: 10
+  safeQps: 5
+scaleServices:
+  - namespace: demo
+    serviceName: web
+    minPod: 0
+    minActivePod: 2
+    idleTime: 60
+  - namespace: demo
+    serviceName: api
+    minPod: 0
+`
+
+func TestPoolHandler_Wake(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(filename, []byte(wakeConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := utils.NewConfig(filename)
+	if conf := config.GetServiceConfig("api.demo"); conf.MinPod != 1 {
+		t.Errorf("minPod 0 without minActivePod should use default, got %d", conf.MinPod)
+	}
+	client := &stubScaler{}
+	pool := NewPoolHandler(config, client)
+	defer pool.RemoveService("web.demo")
+	defer pool.RemoveService("api.demo")
+
+	if pool.wake("api.demo") {
+		t.Error("api.demo can't scale to zero, should be counted")
+	}
+	if !pool.wake("web.demo") {
+		t.Fatal("want web.demo woken up")
+	}
+	for i := 0; i < 50 && client.get() != 2; i++ {
+		time.Sleep(time.Millisecond * 20)
+	}
+	if client.get() != 2 {
+		t.Fatalf("want 2 replicas, got %d", client.get())
+	}
+
+	if pool.idle("web.demo", 60) {
+		t.Error("web.demo just had a request")
+	}
+	pool.mutex.Lock()
+	pool.lastSeen["web.demo"] = time.Now().Add(-time.Minute * 2)
+	pool.mutex.Unlock()
+	if !pool.idle("web.demo", 60) {
+		t.Error("want web.demo idle")
+	}
+}
@@ -11,4 +11,10 @@ type Access interface {
 	AccessTime() time.Time
 	Upstream() string
 	ServiceName() string
+	// NoUpstream 没有可用的后端,如服务缩到0时ingress返回的503
+	NoUpstream() bool
+	// StatusCode 返回给客户端的状态码
+	StatusCode() int
+	// ResponseTime upstream的响应时间,没有时ok为false
+	ResponseTime() (time.Duration, bool)
 }
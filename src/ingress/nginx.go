@@ -3,6 +3,8 @@ package ingress
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -28,6 +30,29 @@ func (na *NGINXAccess) Upstream() string {
 	return na.UpstreamAddr
 }
 
+func (na *NGINXAccess) NoUpstream() bool {
+	return na.UpstreamAddr == "" || na.UpstreamAddr == "-"
+}
+
+func (na *NGINXAccess) StatusCode() int {
+	return na.Status
+}
+
+// ResponseTime nginx重试时upstream_response_time为逗号分隔的多个值,取总和
+func (na *NGINXAccess) ResponseTime() (time.Duration, bool) {
+	var total float64
+	found := false
+	for _, item := range strings.Split(na.UpstreamResponseTime, ",") {
+		seconds, err := strconv.ParseFloat(strings.TrimSpace(item), 64)
+		if err != nil {
+			continue
+		}
+		total += seconds
+		found = true
+	}
+	return time.Duration(total * float64(time.Second)), found
+}
+
 func (na *NGINXAccess) UnmarshalJSON(data []byte) error {
 	tmp := struct {
 		Meta
@@ -12,3 +12,14 @@ func TestEventEcho(t *testing.T) {
 	timeDur := time.Duration(timeMsec)
 	log.Println(time.Unix(timeDur.Milliseconds(), 0))
 }
+
+func TestNGINXAccess_ResponseTime(t *testing.T) {
+	access := &NGINXAccess{UpstreamResponseTime: "0.010, 0.250"}
+	if latency, ok := access.ResponseTime(); !ok || latency != 260*time.Millisecond {
+		t.Errorf("want 260ms, got %s %t", latency, ok)
+	}
+	access.UpstreamResponseTime = "-"
+	if _, ok := access.ResponseTime(); ok {
+		t.Error("want no response time")
+	}
+}
@@ -0,0 +1,164 @@
+package overprovision
+
+import (
+	"expvar"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"auto-scale/src/handler"
+	"auto-scale/src/scale"
+	"auto-scale/src/utils"
+)
+
+// 占位Deployment最近一次设置的副本数
+var placeholderReplicas = expvar.NewInt("placeholder_replicas")
+
+// ReplicaCounter 读取服务当前的副本总数,由handler.PoolHandler实现
+type ReplicaCounter interface {
+	Replicas(serviceName string) (int32, error)
+}
+
+func NewProvisioner(client scale.Scaler, counter ReplicaCounter, config *utils.Config) *Provisioner {
+	op := config.Overprovision
+	return &Provisioner{
+		client:   client,
+		counter:  counter,
+		config:   config,
+		target:   scale.NewTarget("apps/v1", "Deployment", op.Namespace, op.Name),
+		minPods:  op.MinPods,
+		maxPods:  op.MaxPods,
+		window:   time.Duration(op.Window) * time.Second,
+		interval: time.Duration(op.Interval) * time.Second,
+		services: make(map[string]*history),
+	}
+}
+
+// Provisioner 按各服务预计还需要的扩容空间调整占位Deployment的副本数。
+// 只有leader收到Observation,所以只有leader会调整
+type Provisioner struct {
+	mutex    sync.Mutex
+	client   scale.Scaler
+	counter  ReplicaCounter
+	config   *utils.Config
+	target   *scale.Target
+	minPods  int32
+	maxPods  int32
+	window   time.Duration
+	interval time.Duration
+	services map[string]*history
+	last     time.Time // 上次调整的时间
+	running  bool
+}
+
+// history 服务在window内的副本数
+type history struct {
+	replicas int32
+	samples  []sample
+}
+
+type sample struct {
+	at       time.Time
+	replicas int32
+}
+
+// Observe 记录服务当前的副本数,伸缩成功时为新的副本数。
+// 第一次收到服务的Observation时用伸缩前或读取到的副本数作为起点
+func (p *Provisioner) Observe(observation *handler.Observation) {
+	now := time.Now()
+	p.mutex.Lock()
+	_, ok := p.services[observation.ServiceName]
+	p.mutex.Unlock()
+	var current int32
+	if change := observation.Change; !ok && change != nil {
+		current = change.Old
+	} else if !ok {
+		cnt, err := p.counter.Replicas(observation.ServiceName)
+		if err != nil {
+			log.Printf("get %s replicas error %v", observation.ServiceName, err)
+			return
+		}
+		current = cnt
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	h, ok := p.services[observation.ServiceName]
+	if !ok {
+		h = &history{replicas: current, samples: []sample{{at: now, replicas: current}}}
+		p.services[observation.ServiceName] = h
+	}
+	if change := observation.Change; change != nil && !change.DryRun && observation.Err == nil {
+		h.replicas = change.New
+	}
+	h.samples = append(h.samples, sample{at: now, replicas: h.replicas})
+	for len(h.samples) > 0 && now.Sub(h.samples[0].at) > p.window {
+		h.samples = h.samples[1:]
+	}
+	if p.running || now.Sub(p.last) < p.interval {
+		return
+	}
+	p.running, p.last = true, now
+	go p.resize()
+}
+
+// headroom 每个服务(maxPod-当前副本数)乘以window内的增长比例(增长的副本数/当前副本数,最大为1)之和,
+// 限制在[minPods, maxPods]。副本数没有增长的服务不需要预留
+func (p *Provisioner) headroom() int32 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var total float64
+	for serviceName, h := range p.services {
+		conf := p.config.GetServiceConfig(serviceName)
+		if conf == nil {
+			delete(p.services, serviceName)
+			continue
+		}
+		lowest := h.replicas
+		for _, s := range h.samples {
+			if s.replicas < lowest {
+				lowest = s.replicas
+			}
+		}
+		if h.replicas <= lowest || h.replicas >= conf.MaxPod {
+			continue
+		}
+		weight := math.Min(1, float64(h.replicas-lowest)/float64(h.replicas))
+		total += float64(conf.MaxPod-h.replicas) * weight
+	}
+	cnt := int32(math.Ceil(total))
+	if cnt < p.minPods {
+		cnt = p.minPods
+	}
+	if cnt > p.maxPods {
+		cnt = p.maxPods
+	}
+	return cnt
+}
+
+func (p *Provisioner) resize() {
+	defer func() {
+		p.mutex.Lock()
+		p.running = false
+		p.mutex.Unlock()
+	}()
+	cnt := p.headroom()
+	current, err := p.client.GetServicePod(p.target)
+	if err != nil {
+		log.Printf("get placeholder %s error %v", p.target, err)
+		return
+	}
+	if *current == cnt {
+		return
+	}
+	if p.config.Default.DryRun {
+		log.Printf("[dry-run] change placeholder %s from %d to %d", p.target, *current, cnt)
+		return
+	}
+	if err = p.client.ChangeServicePod(p.target, &cnt); err != nil {
+		log.Printf("change placeholder %s error %v", p.target, err)
+		return
+	}
+	placeholderReplicas.Set(int64(cnt))
+	log.Printf("change placeholder %s from %d to %d", p.target, *current, cnt)
+}
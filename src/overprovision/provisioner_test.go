@@ -0,0 +1,91 @@
+package overprovision
+
+import (
+	"testing"
+	"time"
+
+	"auto-scale/src/handler"
+	"auto-scale/src/scale"
+	"auto-scale/src/utils"
+)
+
+type stubScaler struct {
+	replicas int32
+	changed  chan int32
+}
+
+func (s *stubScaler) GetServicePod(target *scale.Target) (*int32, error) {
+	cnt := s.replicas
+	return &cnt, nil
+}
+
+func (s *stubScaler) ChangeServicePod(target *scale.Target, newCount *int32) error {
+	s.replicas = *newCount
+	s.changed <- *newCount
+	return nil
+}
+
+type stubCounter map[string]int32
+
+func (c stubCounter) Replicas(serviceName string) (int32, error) {
+	return c[serviceName], nil
+}
+
+func newTestConfig(t *testing.T) *utils.Config {
+	config := &utils.Config{Default: &utils.DefaultConfig{MaxPod: 10, MinPod: 1, MaxQps: 10, SafeQps: 5}}
+	for name, maxPod := range map[string]int32{"web": 20, "api": 10} {
+		conf, err := config.NewDynamicConfig(&utils.ServiceSpec{Namespace: "shop", ServiceName: name, MaxPod: maxPod})
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.AddDiscoveredService(conf)
+	}
+	config.Overprovision.Enabled, config.Overprovision.MaxPods = true, 6
+	return config
+}
+
+func TestProvisioner_Observe(t *testing.T) {
+	config := newTestConfig(t)
+	client := &stubScaler{changed: make(chan int32, 1)}
+	p := NewProvisioner(client, stubCounter{"api.shop": 4, "web.shop": 4}, config)
+	p.window, p.interval = time.Minute, time.Hour
+	// api没有增长,不需要预留
+	p.Observe(&handler.Observation{ServiceName: "api.shop", Desired: 4})
+	p.Observe(&handler.Observation{ServiceName: "web.shop", Desired: 4})
+	if cnt := p.headroom(); cnt != 0 {
+		t.Fatalf("want no headroom without growth, got %d", cnt)
+	}
+	// web从4扩到5: (20-5)*(1/5)=3
+	p.Observe(&handler.Observation{ServiceName: "web.shop", Desired: 5, Change: &scale.Change{Old: 4, New: 5}})
+	if cnt := p.headroom(); cnt != 3 {
+		t.Fatalf("want 3, got %d", cnt)
+	}
+	// web从4扩到8: (20-8)*(4/8)=6,api从4扩到10已经到maxPod
+	p.Observe(&handler.Observation{ServiceName: "web.shop", Desired: 8, Change: &scale.Change{Old: 5, New: 8}})
+	p.Observe(&handler.Observation{ServiceName: "api.shop", Desired: 10, Change: &scale.Change{Old: 4, New: 10}})
+	if cnt := p.headroom(); cnt != 6 {
+		t.Fatalf("want 6, got %d", cnt)
+	}
+	p.interval = 0
+	p.Observe(&handler.Observation{ServiceName: "web.shop", Desired: 8})
+	select {
+	case cnt := <-client.changed:
+		if cnt != 6 {
+			t.Errorf("want placeholder 6, got %d", cnt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("placeholder not resized")
+	}
+}
+
+func TestProvisioner_ObserveSeed(t *testing.T) {
+	p := NewProvisioner(&stubScaler{}, stubCounter{"web.shop": 4}, newTestConfig(t))
+	p.window, p.interval = time.Minute, time.Hour
+	// 冷却中期望8,实际还是4
+	p.Observe(&handler.Observation{ServiceName: "web.shop", Desired: 8})
+	// (20-8)*(4/8)=6
+	p.Observe(&handler.Observation{ServiceName: "web.shop", Desired: 8, Change: &scale.Change{Old: 4, New: 8}})
+	if cnt := p.headroom(); cnt != 6 {
+		t.Fatalf("want 6, got %d", cnt)
+	}
+}
@@ -0,0 +1,180 @@
+package scale
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const backendTimeout = time.Second * 30
+
+// backendArgs 命令模板和webhook中可用的字段
+type backendArgs struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Replicas  int32  `json:"replicas"`
+}
+
+// NewExecScaler 通过命令读取和修改副本数,适用于docker-compose、虚拟机等。
+// get、set为text/template模板,可用{{.Namespace}}、{{.Name}}、{{.Replicas}},由sh -c执行,get的输出为副本数
+func NewExecScaler(get, set string) (*execScaler, error) {
+	getTmpl, err := template.New("get").Parse(get)
+	if err != nil {
+		return nil, fmt.Errorf("parse get command error %w", err)
+	}
+	setTmpl, err := template.New("set").Parse(set)
+	if err != nil {
+		return nil, fmt.Errorf("parse set command error %w", err)
+	}
+	return &execScaler{get: getTmpl, set: setTmpl, timeout: backendTimeout}, nil
+}
+
+type execScaler struct {
+	get     *template.Template
+	set     *template.Template
+	timeout time.Duration
+}
+
+func (es *execScaler) run(tmpl *template.Template, args *backendArgs) (string, error) {
+	var command bytes.Buffer
+	if err := tmpl.Execute(&command, args); err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), es.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command.String())
+	// 超时时kill整个进程组,sh启动的子进程也会退出
+	setProcessGroup(cmd)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("%s: %w", command.String(), err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("%s: %w", command.String(), ctx.Err())
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w %s", command.String(), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func (es *execScaler) GetServicePod(target *Target) (*int32, error) {
+	out, err := es.run(es.get, &backendArgs{Namespace: target.Namespace, Name: target.Name})
+	if err != nil {
+		return nil, err
+	}
+	cnt, err := strconv.ParseInt(out, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("get command should print replicas, got %q", out)
+	}
+	replicas := int32(cnt)
+	return &replicas, nil
+}
+
+func (es *execScaler) ChangeServicePod(target *Target, newCount *int32) error {
+	_, err := es.run(es.set, &backendArgs{Namespace: target.Namespace, Name: target.Name, Replicas: *newCount})
+	return err
+}
+
+// NewWebhookScaler 通过HTTP接口读取和修改副本数。
+// GET url?namespace=&name= 返回{"replicas": n},POST url 提交{"namespace","name","replicas"}
+func NewWebhookScaler(endpoint, token string) *webhookScaler {
+	return &webhookScaler{endpoint: endpoint, token: token, client: &http.Client{Timeout: backendTimeout}}
+}
+
+type webhookScaler struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+func (ws *webhookScaler) do(req *http.Request, result interface{}) error {
+	if ws.token != "" {
+		req.Header.Set("Authorization", "Bearer "+ws.token)
+	}
+	resp, err := ws.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s", req.Method, ws.endpoint, resp.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (ws *webhookScaler) GetServicePod(target *Target) (*int32, error) {
+	query := url.Values{"namespace": {target.Namespace}, "name": {target.Name}}
+	sep := "?"
+	if strings.Contains(ws.endpoint, "?") {
+		sep = "&"
+	}
+	req, err := http.NewRequest(http.MethodGet, ws.endpoint+sep+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	result := new(backendArgs)
+	if err = ws.do(req, result); err != nil {
+		return nil, err
+	}
+	return &result.Replicas, nil
+}
+
+func (ws *webhookScaler) ChangeServicePod(target *Target, newCount *int32) error {
+	body, err := json.Marshal(&backendArgs{Namespace: target.Namespace, Name: target.Name, Replicas: *newCount})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, ws.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return ws.do(req, nil)
+}
+
+// SetBackend 服务使用Kubernetes以外的Scaler,为nil时恢复使用默认的client
+func (sm *ScalerManage) SetBackend(serviceName string, backend Scaler) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	if backend == nil {
+		delete(sm.backends, serviceName)
+		delete(sm.shadows, serviceName)
+		return
+	}
+	sm.backends[serviceName] = backend
+	sm.shadows[serviceName] = newShadowScaler(backend)
+}
+
+// backend 服务实际使用的Scaler,RolloutChecker等可选接口也按它判断
+func (sm *ScalerManage) backend(serviceName string) Scaler {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	if backend, ok := sm.backends[serviceName]; ok {
+		return backend
+	}
+	return sm.client
+}
@@ -0,0 +1,83 @@
+package scale
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestExecScaler(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "replicas")
+	es, err := NewExecScaler("cat "+file+" 2>/dev/null || echo 1", "echo {{.Replicas}} > "+file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := NewScaler(3, 60, nil)
+	sm.SetBackend("web.compose", es)
+	newCount := int32(3)
+	change, err := sm.ChangeServicePod("web.compose", &newCount, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.Old != 1 || change.New != 3 {
+		t.Fatalf("want 1 -> 3, got %s", change)
+	}
+	cnt, err := es.GetServicePod(NewTarget("", "", "compose", "web"))
+	if err != nil || *cnt != 3 {
+		t.Fatalf("want 3, got %v %v", cnt, err)
+	}
+	bad, _ := NewExecScaler("echo many", "false")
+	if _, err = bad.GetServicePod(NewTarget("", "", "compose", "web")); err == nil {
+		t.Error("want invalid output error")
+	}
+	if err = bad.ChangeServicePod(NewTarget("", "", "compose", "web"), &newCount); err == nil {
+		t.Error("want command error")
+	}
+	// 子进程持有stdout时也要在超时后返回
+	slow, _ := NewExecScaler("sleep 10 | cat", "true")
+	slow.timeout = time.Millisecond * 200
+	start := time.Now()
+	if _, err = slow.GetServicePod(NewTarget("", "", "compose", "web")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second*5 {
+		t.Errorf("timeout took %s", elapsed)
+	}
+}
+
+func TestWebhookScaler(t *testing.T) {
+	replicas := map[string]int32{"api": 2}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(map[string]int32{"replicas": replicas[r.URL.Query().Get("name")]})
+			return
+		}
+		args := new(backendArgs)
+		json.NewDecoder(r.Body).Decode(args)
+		replicas[args.Name] = args.Replicas
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	sm := NewScaler(3, 60, nil)
+	sm.SetBackend("api.vm", NewWebhookScaler(server.URL, "secret"))
+	newCount := int32(5)
+	if _, err := sm.ChangeServicePod("api.vm", &newCount, nil); err != nil {
+		t.Fatal(err)
+	}
+	if replicas["api"] != 5 {
+		t.Fatalf("want 5, got %d", replicas["api"])
+	}
+	if _, err := NewWebhookScaler(server.URL, "").GetServicePod(NewTarget("", "", "vm", "api")); err == nil {
+		t.Error("want unauthorized error")
+	}
+}
@@ -0,0 +1,228 @@
+package scale
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// 因为预算不足没有得到期望副本数的服务,值为差的副本数
+var starvedServices = expvar.NewMap("starved_services")
+
+// BudgetLimit 副本数或CPU核数的上限,0为不限制
+type BudgetLimit struct {
+	MaxPods int32
+	MaxCPU  float64
+}
+
+func (bl BudgetLimit) empty() bool {
+	return bl.MaxPods <= 0 && bl.MaxCPU <= 0
+}
+
+// Budget 所有服务和每个namespace的预算
+type Budget struct {
+	BudgetLimit
+	Namespaces map[string]BudgetLimit
+}
+
+// PodRequester 取得工作负载Pod模板的requests,用于CPU预算
+type PodRequester interface {
+	PodRequests(target *Target) (corev1.ResourceList, error)
+}
+
+// demand 服务最近一次的期望副本数
+type demand struct {
+	serviceName string
+	namespace   string
+	priority    int
+	min         int32
+	want        int32
+	cpu         float64 // 每个Pod的CPU requests
+	cpuAt       time.Time
+}
+
+func (kc *k8SClient) PodRequests(target *Target) (corev1.ResourceList, error) {
+	return kc.podRequests(target)
+}
+
+func (sm *ScalerManage) SetBudget(budget *Budget) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.budget = budget
+}
+
+// Allocate 记录服务的期望副本数,超过预算时按优先级、同优先级按需求比例分配,返回分到的副本数。
+// min总是保留。没有设置预算时返回want
+func (sm *ScalerManage) Allocate(serviceName, namespace string, priority int, min, want int32) int32 {
+	sm.mutex.Lock()
+	budget := sm.budget
+	d, ok := sm.demands[serviceName]
+	if !ok {
+		d = &demand{serviceName: serviceName}
+		sm.demands[serviceName] = d
+	}
+	d.namespace, d.priority, d.min, d.want = namespace, priority, min, want
+	refresh := budget != nil && (budget.MaxCPU > 0 || budget.Namespaces[namespace].MaxCPU > 0) &&
+		time.Since(d.cpuAt) > resolverResync
+	sm.mutex.Unlock()
+	if budget == nil {
+		return want
+	}
+	if refresh {
+		cpu := sm.podCPU(serviceName)
+		sm.mutex.Lock()
+		d.cpu, d.cpuAt = cpu, time.Now()
+		sm.mutex.Unlock()
+	}
+
+	sm.mutex.Lock()
+	demands := make([]*demand, 0, len(sm.demands))
+	for _, d := range sm.demands {
+		copied := *d
+		demands = append(demands, &copied)
+	}
+	sm.mutex.Unlock()
+	// 先在namespace内分配,再在所有服务间分配
+	granted := make(map[string]int32, len(demands))
+	byNamespace := make(map[string][]*demand)
+	for _, d := range demands {
+		granted[d.serviceName] = d.want
+		byNamespace[d.namespace] = append(byNamespace[d.namespace], d)
+	}
+	for ns, items := range byNamespace {
+		if limit, ok := budget.Namespaces[ns]; ok && !limit.empty() {
+			allocateLimit(limit, items, granted)
+		}
+	}
+	if !budget.BudgetLimit.empty() {
+		allocateLimit(budget.BudgetLimit, demands, granted)
+	}
+	got := granted[serviceName]
+	sm.starved(serviceName, want, got)
+	return got
+}
+
+// allocateLimit 依次按副本数和CPU分配,want为上一步分到的副本数
+func allocateLimit(limit BudgetLimit, items []*demand, granted map[string]int32) {
+	if limit.MaxPods > 0 {
+		allocate(float64(limit.MaxPods), items, granted, func(*demand) float64 { return 1 })
+	}
+	if limit.MaxCPU > 0 {
+		allocate(limit.MaxCPU, items, granted, func(d *demand) float64 { return d.cpu })
+	}
+}
+
+// allocate 每个服务先保留min,剩余的按优先级从高到低分配,同一优先级不够时按需求比例分配,余数按最大余额法
+func allocate(budget float64, items []*demand, granted map[string]int32, cost func(*demand) float64) {
+	remaining := budget
+	for _, d := range items {
+		remaining -= float64(minInt32(d.min, granted[d.serviceName])) * cost(d)
+	}
+	tiers := make(map[int][]*demand)
+	priorities := make([]int, 0)
+	for _, d := range items {
+		if _, ok := tiers[d.priority]; !ok {
+			priorities = append(priorities, d.priority)
+		}
+		tiers[d.priority] = append(tiers[d.priority], d)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(priorities)))
+	for _, priority := range priorities {
+		tier := tiers[priority]
+		sort.Slice(tier, func(i, j int) bool { return tier[i].serviceName < tier[j].serviceName })
+		extras := make([]int32, len(tier))
+		var need float64
+		for i, d := range tier {
+			if extra := granted[d.serviceName] - d.min; extra > 0 {
+				extras[i] = extra
+				need += float64(extra) * cost(d)
+			}
+		}
+		if need == 0 || need <= remaining {
+			remaining -= need
+			continue
+		}
+		ratio := math.Max(remaining, 0) / need
+		fractions := make([]float64, len(tier))
+		order := make([]int, 0, len(tier))
+		for i, d := range tier {
+			if extras[i] == 0 || cost(d) == 0 {
+				// 不占用该预算的服务保持不变
+				continue
+			}
+			exact := float64(extras[i]) * ratio
+			got := int32(math.Floor(exact))
+			remaining -= float64(got) * cost(d)
+			granted[d.serviceName] = d.min + got
+			fractions[i] = exact - float64(got)
+			order = append(order, i)
+		}
+		sort.SliceStable(order, func(a, b int) bool { return fractions[order[a]] > fractions[order[b]] })
+		for _, i := range order {
+			d := tier[i]
+			if fractions[i] > 0 && cost(d) <= remaining {
+				granted[d.serviceName]++
+				remaining -= cost(d)
+			}
+		}
+		remaining = 0
+	}
+}
+
+func minInt32(a, b int32) int32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// podCPU 服务所有伸缩目标Pod的平均CPU requests,查询失败时为0
+func (sm *ScalerManage) podCPU(serviceName string) float64 {
+	requester, ok := sm.backend(serviceName).(PodRequester)
+	if !ok {
+		return 0
+	}
+	targets, err := sm.resolve(serviceName)
+	if err != nil || len(targets) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, target := range targets {
+		requests, err := requester.PodRequests(target)
+		if err != nil {
+			log.Printf("get %s(%s) pod requests error %v", serviceName, target, err)
+			continue
+		}
+		sum += float64(requests.Cpu().MilliValue()) / 1000
+	}
+	return sum / float64(len(targets))
+}
+
+// starved 分到的副本数少于期望时通知一次,恢复后记录日志
+func (sm *ScalerManage) starved(serviceName string, want, got int32) {
+	sm.mutex.Lock()
+	was := sm.starving[serviceName]
+	sm.starving[serviceName] = got < want
+	notify := sm.notify
+	sm.mutex.Unlock()
+	if got >= want {
+		starvedServices.Delete(serviceName)
+		if was {
+			log.Printf("%s is no longer limited by budget", serviceName)
+		}
+		return
+	}
+	v := new(expvar.Int)
+	v.Set(int64(want - got))
+	starvedServices.Set(serviceName, v)
+	msg := fmt.Sprintf("%s starved by budget, wants %d but gets %d", serviceName, want, got)
+	log.Println(msg)
+	if !was && notify != nil {
+		notify(msg)
+	}
+}
@@ -0,0 +1,53 @@
+package scale
+
+import "testing"
+
+func TestScalerManage_Allocate(t *testing.T) {
+	sm := NewScaler(3, 60, &stubScaler{replicas: map[string]int32{}})
+	var msgs []string
+	sm.SetNotify(func(msg string) { msgs = append(msgs, msg) })
+	sm.SetBudget(&Budget{BudgetLimit: BudgetLimit{MaxPods: 20}, Namespaces: map[string]BudgetLimit{"batch": {MaxPods: 4}}})
+
+	if got := sm.Allocate("web.shop", "shop", 10, 2, 12); got != 12 {
+		t.Fatalf("want 12 within budget, got %d", got)
+	}
+	// 同优先级按需求比例分配剩余的6个: api要8个,cart要4个
+	sm.Allocate("api.shop", "shop", 0, 1, 9)
+	if got := sm.Allocate("cart.shop", "shop", 0, 1, 5); got != 3 {
+		t.Fatalf("want cart 3, got %d", got)
+	}
+	if got := sm.Allocate("api.shop", "shop", 0, 1, 9); got != 5 {
+		t.Fatalf("want api 5, got %d", got)
+	}
+	// namespace预算
+	if got := sm.Allocate("job.batch", "batch", 100, 1, 6); got != 4 {
+		t.Fatalf("want job capped by namespace budget to 4, got %d", got)
+	}
+	// 优先级高的服务先满足
+	if got := sm.Allocate("web.shop", "shop", 10, 2, 12); got != 12 {
+		t.Fatalf("want web keeps 12, got %d", got)
+	}
+	if len(msgs) == 0 || starvedServices.Get("cart.shop") == nil {
+		t.Errorf("want starved services reported, got %v", msgs)
+	}
+	sm.SetBudget(nil)
+	if got := sm.Allocate("cart.shop", "shop", 0, 1, 5); got != 5 {
+		t.Fatalf("want 5 without budget, got %d", got)
+	}
+}
+
+func TestAllocate_CPU(t *testing.T) {
+	items := []*demand{
+		{serviceName: "a", min: 1, want: 4, cpu: 2},
+		{serviceName: "b", min: 1, want: 4, cpu: 0.5},
+	}
+	granted := map[string]int32{"a": 4, "b": 4}
+	allocateLimit(BudgetLimit{MaxCPU: 6}, items, granted)
+	var used float64
+	for _, d := range items {
+		used += float64(granted[d.serviceName]) * d.cpu
+	}
+	if used > 6 || granted["a"] < 1 || granted["b"] < 1 {
+		t.Fatalf("want within 6 cores, got %v", granted)
+	}
+}
@@ -0,0 +1,265 @@
+package scale
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// 扩容后等待调度的时间,之后仍然Pending的Pod会被通知
+var pendingDelay = time.Minute
+
+// CapacityChecker 扩容前按Pod模板的requests检查ResourceQuota和集群剩余资源,返回还能放下的Pod数和不足的原因
+type CapacityChecker interface {
+	Capacity(target *Target, extra int32) (int32, string, error)
+}
+
+// PendingChecker 扩容后找出调度不了的Pod
+type PendingChecker interface {
+	PendingPods(target *Target) (int, string, error)
+}
+
+func (kc *k8SClient) Capacity(target *Target, extra int32) (int32, string, error) {
+	requests, err := kc.podRequests(target)
+	if err != nil {
+		return 0, "", err
+	}
+	fits, reasons := int64(extra), make([]string, 0)
+	limit := func(n int64, reason string) {
+		if n < int64(extra) {
+			reasons = append(reasons, fmt.Sprintf("%s allows %d", reason, n))
+		}
+		if n < fits {
+			fits = n
+		}
+	}
+	quotas, err := kc.clientset.CoreV1().ResourceQuotas(target.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return 0, "", err
+	}
+	for _, quota := range quotas.Items {
+		for name, perPod := range quotaUsage(requests) {
+			hard, ok := quota.Status.Hard[name]
+			if !ok {
+				continue
+			}
+			remaining := hard.DeepCopy()
+			remaining.Sub(quota.Status.Used[name])
+			limit(count(remaining, perPod), fmt.Sprintf("quota %s %s remaining %s", quota.Name, name, remaining.String()))
+		}
+	}
+	if requests.Cpu().IsZero() && requests.Memory().IsZero() {
+		return int32(fits), strings.Join(reasons, ", "), nil
+	}
+	free, err := kc.clusterFree()
+	if err != nil {
+		return 0, "", err
+	}
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		perPod := requests[name]
+		available := free[name]
+		limit(count(available, perPod), fmt.Sprintf("cluster free %s %s", name, available.String()))
+	}
+	return int32(fits), strings.Join(reasons, ", "), nil
+}
+
+// count 剩余资源能放下的Pod数,不需要该资源时不限制
+func count(remaining, perPod resource.Quantity) int64 {
+	if perPod.IsZero() {
+		return math.MaxInt32
+	}
+	n := remaining.MilliValue() / perPod.MilliValue()
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// quotaUsage 每个Pod在ResourceQuota中占用的量
+func quotaUsage(requests corev1.ResourceList) map[corev1.ResourceName]resource.Quantity {
+	return map[corev1.ResourceName]resource.Quantity{
+		corev1.ResourcePods:           resource.MustParse("1"),
+		corev1.ResourceCPU:            requests[corev1.ResourceCPU],
+		corev1.ResourceRequestsCPU:    requests[corev1.ResourceCPU],
+		corev1.ResourceMemory:         requests[corev1.ResourceMemory],
+		corev1.ResourceRequestsMemory: requests[corev1.ResourceMemory],
+	}
+}
+
+// podRequests 工作负载Pod模板的requests,init容器取最大值
+func (kc *k8SClient) podRequests(target *Target) (corev1.ResourceList, error) {
+	gvr, err := kc.resource(target)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := kc.dynamic.Resource(gvr).Namespace(target.Namespace).Get(context.TODO(), target.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	tmpl, found, err := unstructured.NestedMap(obj.Object, "spec", "template")
+	if err != nil || !found {
+		return nil, fmt.Errorf("%s has no pod template", target)
+	}
+	podTemplate := new(corev1.PodTemplateSpec)
+	if err = runtime.DefaultUnstructuredConverter.FromUnstructured(tmpl, podTemplate); err != nil {
+		return nil, err
+	}
+	return podSpecRequests(&podTemplate.Spec), nil
+}
+
+func podSpecRequests(spec *corev1.PodSpec) corev1.ResourceList {
+	requests := corev1.ResourceList{}
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		sum := resource.Quantity{}
+		for _, container := range spec.Containers {
+			sum.Add(container.Resources.Requests[name])
+		}
+		for _, container := range spec.InitContainers {
+			if q := container.Resources.Requests[name]; q.Cmp(sum) > 0 {
+				sum = q.DeepCopy()
+			}
+		}
+		requests[name] = sum
+	}
+	return requests
+}
+
+// clusterFree 可调度节点的allocatable减去已调度Pod的requests
+func (kc *k8SClient) clusterFree() (corev1.ResourceList, error) {
+	nodes, err := kc.clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	free := corev1.ResourceList{}
+	schedulable := make(map[string]bool, len(nodes.Items))
+	for _, node := range nodes.Items {
+		if node.Spec.Unschedulable {
+			continue
+		}
+		schedulable[node.Name] = true
+		for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			q := free[name]
+			q.Add(node.Status.Allocatable[name])
+			free[name] = q
+		}
+	}
+	pods, err := kc.clientset.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{
+		FieldSelector: "status.phase!=Succeeded,status.phase!=Failed",
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods.Items {
+		if !schedulable[pod.Spec.NodeName] {
+			continue
+		}
+		requests := podSpecRequests(&pod.Spec)
+		for name, q := range requests {
+			left := free[name]
+			left.Sub(q)
+			free[name] = left
+		}
+	}
+	return free, nil
+}
+
+func (kc *k8SClient) PendingPods(target *Target) (int, string, error) {
+	gvr, err := kc.resource(target)
+	if err != nil {
+		return 0, "", err
+	}
+	s, err := kc.scales.Scales(target.Namespace).Get(context.TODO(), gvr.GroupResource(), target.Name, metav1.GetOptions{})
+	if err != nil {
+		return 0, "", err
+	}
+	selector, err := labels.Parse(s.Status.Selector)
+	if err != nil {
+		return 0, "", err
+	}
+	pods, err := kc.clientset.CoreV1().Pods(target.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	pending, reason := 0, ""
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodPending {
+			continue
+		}
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse {
+				pending++
+				reason = condition.Message
+			}
+		}
+	}
+	return pending, reason, nil
+}
+
+// capacity 扩容的工作负载按剩余资源减少副本数,返回不足的原因。查询失败时不限制
+func (sm *ScalerManage) capacity(serviceName string, change *Change) string {
+	checker, ok := sm.backend(serviceName).(CapacityChecker)
+	if !ok {
+		return ""
+	}
+	shortfalls := make([]string, 0)
+	for _, wc := range change.Workloads {
+		extra := wc.New - wc.Old
+		if extra <= 0 {
+			continue
+		}
+		fits, reason, err := checker.Capacity(wc.Target, extra)
+		if err != nil {
+			log.Printf("check %s(%s) capacity error %v", serviceName, wc.Target, err)
+			continue
+		}
+		if fits >= extra {
+			continue
+		}
+		wc.New = wc.Old + fits
+		change.New -= extra - fits
+		shortfalls = append(shortfalls, fmt.Sprintf("%s %s short of %d pods: %s", wc.Target.Kind, wc.Target.Name, extra-fits, reason))
+	}
+	return strings.Join(shortfalls, "; ")
+}
+
+// reportPending 扩容一段时间后仍然调度不了的Pod通过notify通知
+func (sm *ScalerManage) reportPending(serviceName string, change *Change) {
+	checker, ok := sm.backend(serviceName).(PendingChecker)
+	if !ok {
+		return
+	}
+	time.Sleep(pendingDelay)
+	for _, wc := range change.Workloads {
+		if wc.New <= wc.Old {
+			continue
+		}
+		pending, reason, err := checker.PendingPods(wc.Target)
+		if err != nil {
+			log.Printf("check %s(%s) pending pods error %v", serviceName, wc.Target, err)
+			continue
+		}
+		if pending == 0 {
+			continue
+		}
+		msg := fmt.Sprintf("%s(%s) has %d pending pods after scaling to %d: %s", serviceName, wc.Target, pending, wc.New, reason)
+		log.Println(msg)
+		sm.mutex.Lock()
+		notify := sm.notify
+		sm.mutex.Unlock()
+		if notify != nil {
+			notify(msg)
+		}
+	}
+}
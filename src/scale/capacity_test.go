@@ -0,0 +1,78 @@
+package scale
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestScalerManage_Capacity(t *testing.T) {
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "compute", Namespace: "demo"},
+		Status: corev1.ResourceQuotaStatus{
+			Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("2")},
+			Used: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("1")},
+		},
+	}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("4"), corev1.ResourceMemory: resource.MustParse("1Gi"),
+		}},
+	}
+	pending := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "demo"},
+		Status: corev1.PodStatus{Phase: corev1.PodPending, Conditions: []corev1.PodCondition{
+			{Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Message: "0/1 nodes are available: 1 Insufficient memory."},
+		}},
+	}
+	replicas := map[string]int32{"deployments/demo/web": 2}
+	kc, _ := newFakeK8SClient(replicas, quota, node, pending)
+	kc.recorder = record.NewFakeRecorder(10)
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "demo"},
+		"spec": map[string]interface{}{"template": map[string]interface{}{"spec": map[string]interface{}{
+			"containers": []interface{}{map[string]interface{}{
+				"name":      "web",
+				"resources": map[string]interface{}{"requests": map[string]interface{}{"cpu": "500m", "memory": "256Mi"}},
+			}},
+		}}},
+	}}
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	if _, err := kc.dynamic.Resource(gvr).Namespace("demo").Create(context.TODO(), deployment, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	fits, reason, err := kc.Capacity(NewTarget("", "", "demo", "web"), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// quota剩余1核放下2个,集群1Gi内存放下4个
+	if fits != 2 || !strings.Contains(reason, "quota compute") || !strings.Contains(reason, "cluster free memory") {
+		t.Fatalf("want 2 fits limited by quota and memory, got %d %s", fits, reason)
+	}
+
+	sm := NewScaler(3, 60, kc)
+	sm.SetTargets("web.demo", []*Target{NewTarget("", "", "demo", "web")})
+	newCount := int32(6)
+	change, err := sm.ChangeServicePod("web.demo", &newCount, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.New != 4 || replicas["deployments/demo/web"] != 4 || !strings.Contains(change.String(), "capped") {
+		t.Fatalf("want capped to 4, got %s", change)
+	}
+
+	n, reason, err := kc.PendingPods(NewTarget("", "", "demo", "web"))
+	if err != nil || n != 1 || !strings.Contains(reason, "Insufficient memory") {
+		t.Fatalf("want 1 pending pod, got %d %s %v", n, reason, err)
+	}
+}
@@ -0,0 +1,92 @@
+package scale
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Change 一次伸缩的结果,一个Service可能对应多个工作负载
+type Change struct {
+	ServiceName string
+	Old         int32
+	New         int32
+	Workloads   []*WorkloadChange
+	DryRun      bool    // 试运行,没有真正修改
+	Reason      *Reason // 触发伸缩的观测值
+	Shortfall   string  // ResourceQuota或集群资源不足,扩容的副本数被减少的原因
+}
+
+type WorkloadChange struct {
+	Target *Target
+	Old    int32
+	New    int32
+}
+
+func (c *Change) String() string {
+	msg := fmt.Sprintf("%s from %d to %d", c.ServiceName, c.Old, c.New)
+	if c.DryRun {
+		msg = "[dry-run] " + msg
+	}
+	if len(c.Workloads) >= 2 {
+		items := make([]string, len(c.Workloads))
+		for i, wc := range c.Workloads {
+			items[i] = fmt.Sprintf("%s %s %d->%d", wc.Target.Kind, wc.Target.Name, wc.Old, wc.New)
+		}
+		msg = fmt.Sprintf("%s (%s)", msg, strings.Join(items, ", "))
+	}
+	if c.Shortfall != "" {
+		msg = fmt.Sprintf("%s, capped: %s", msg, c.Shortfall)
+	}
+	return msg
+}
+
+// split 按权重把total分给各个工作负载,余数按最大余额法分配。
+// 权重大于0的工作负载至少保留一个副本,避免金丝雀版本被缩没了
+func split(total int32, weights []float64) []int32 {
+	result := make([]int32, len(weights))
+	var sum float64
+	for _, w := range weights {
+		sum += w
+	}
+	if sum <= 0 {
+		weights = make([]float64, len(weights))
+		for i := range weights {
+			weights[i] = 1
+		}
+		sum = float64(len(weights))
+	}
+	var assigned int32
+	remainders := make([]int, len(weights))
+	for i, w := range weights {
+		exact := float64(total) * w / sum
+		result[i] = int32(math.Floor(exact))
+		assigned += result[i]
+		remainders[i] = i
+	}
+	sort.SliceStable(remainders, func(a, b int) bool {
+		ia, ib := remainders[a], remainders[b]
+		return float64(total)*weights[ia]/sum-float64(result[ia]) > float64(total)*weights[ib]/sum-float64(result[ib])
+	})
+	for k := 0; assigned < total; k++ {
+		result[remainders[k%len(remainders)]]++
+		assigned++
+	}
+	for i := range result {
+		if result[i] > 0 || weights[i] <= 0 {
+			continue
+		}
+		most := 0
+		for j := range result {
+			if result[j] > result[most] {
+				most = j
+			}
+		}
+		if result[most] > 1 {
+			result[most]--
+			result[i]++
+		}
+	}
+	return result
+}
@@ -0,0 +1,219 @@
+package scale
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	scaleclient "k8s.io/client-go/scale"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	metricsclient "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+const (
+	DefaultAPIVersion = "apps/v1"
+	DefaultKind       = "Deployment"
+)
+
+var (
+	// 修改副本数失败后的指数退避重试
+	changeBackoff = wait.Backoff{
+		Steps:    5,
+		Duration: 200 * time.Millisecond,
+		Factor:   2,
+		Jitter:   0.1,
+	}
+)
+
+// Target 伸缩的目标工作负载,任何实现了/scale子资源的类型都可以
+type Target struct {
+	APIVersion string
+	Kind       string
+	Namespace  string
+	Name       string
+	// 多个工作负载时分配副本数的权重,都为0时按当前副本数的比例分配
+	Weight float64
+}
+
+func NewTarget(apiVersion, kind, namespace, name string) *Target {
+	if apiVersion == "" {
+		apiVersion = DefaultAPIVersion
+	}
+	if kind == "" {
+		kind = DefaultKind
+	}
+	return &Target{APIVersion: apiVersion, Kind: kind, Namespace: namespace, Name: name}
+}
+
+func (t *Target) String() string {
+	return fmt.Sprintf("%s/%s %s.%s", t.APIVersion, t.Kind, t.Name, t.Namespace)
+}
+
+func (t *Target) groupVersionKind() (schema.GroupVersionKind, error) {
+	gv, err := schema.ParseGroupVersion(t.APIVersion)
+	if err != nil {
+		return schema.GroupVersionKind{}, err
+	}
+	return gv.WithKind(t.Kind), nil
+}
+
+type Scaler interface {
+	GetServicePod(target *Target) (*int32, error)
+	ChangeServicePod(target *Target, newCount *int32) error
+}
+
+// getConfig 指定了kubeconfig或context时使用kubeconfig,否则优先使用集群内的ServiceAccount,
+// 不在集群内时使用KUBECONFIG或~/.kube/config
+func getConfig(kubeconfig, context string) (*rest.Config, error) {
+	if kubeconfig == "" && context == "" {
+		config, err := rest.InClusterConfig()
+		if err == nil {
+			log.Println("guess inside the cluster")
+			return config, nil
+		}
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: context}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+}
+
+// NewK8SClient 默认集群的client,由--kubeconfig、--context指定
+func NewK8SClient() *k8SClient {
+	kc, err := ClusterClient(DefaultCluster)
+	if err != nil {
+		log.Fatalln("init client failed", err)
+	}
+	return kc
+}
+
+func newK8SClient(config *rest.Config) (*k8SClient, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(clientset.Discovery()))
+	resolver := scaleclient.NewDiscoveryScaleKindResolver(clientset.Discovery())
+	scales, err := scaleclient.NewForConfig(config, mapper, dynamic.LegacyAPIPathResolverFunc, resolver)
+	if err != nil {
+		return nil, fmt.Errorf("init scale client failed %w", err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("init dynamic client failed %w", err)
+	}
+	// 没有安装metrics-server时查询指标会失败,只按QPS伸缩
+	metrics, err := metricsclient.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("init metrics client failed %w", err)
+	}
+	return &k8SClient{
+		clientset: clientset,
+		dynamic:   dynamicClient,
+		mapper:    mapper,
+		scales:    scales,
+		metrics:   metrics,
+		resolver:  NewResolver(clientset),
+		recorder:  newEventRecorder(clientset),
+	}, nil
+}
+
+type k8SClient struct {
+	clientset kubernetes.Interface
+	dynamic   dynamic.Interface
+	mapper    meta.RESTMapper
+	scales    scaleclient.ScalesGetter
+	metrics   metricsclient.Interface
+	resolver  *Resolver
+	recorder  record.EventRecorder
+	// 监听副本数的informer,key为GVR/namespace
+	watchMutex sync.Mutex
+	watches    map[string]*replicaWatch
+}
+
+func (kc *k8SClient) Clientset() kubernetes.Interface {
+	return kc.clientset
+}
+
+func (kc *k8SClient) Dynamic() dynamic.Interface {
+	return kc.dynamic
+}
+
+func (kc *k8SClient) ResolveTargets(namespace, service string) ([]*Target, error) {
+	return kc.resolver.ResolveTargets(namespace, service)
+}
+
+// resource 将Kind转换为/scale子资源所需的GroupVersionResource
+func (kc *k8SClient) resource(target *Target) (schema.GroupVersionResource, error) {
+	gvk, err := target.groupVersionKind()
+	if err != nil {
+		return schema.GroupVersionResource{}, err
+	}
+	mapping, err := kc.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		// 可能是新安装的CRD,刷新发现缓存后再试一次
+		if resetter, ok := kc.mapper.(interface{ Reset() }); ok {
+			resetter.Reset()
+			mapping, err = kc.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		}
+	}
+	if err != nil {
+		return schema.GroupVersionResource{}, err
+	}
+	return mapping.Resource, nil
+}
+
+func (kc *k8SClient) GetServicePod(target *Target) (*int32, error) {
+	gvr, err := kc.resource(target)
+	if err != nil {
+		return nil, err
+	}
+	s, err := kc.scales.Scales(target.Namespace).Get(context.TODO(), gvr.GroupResource(), target.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &s.Spec.Replicas, nil
+}
+
+// ChangeServicePod 只合并修改/scale子资源的spec.replicas,不会与CI/CD对其它字段的修改冲突。
+// 有HPA管理该工作负载时改为修改HPA的minReplicas,查不到HPA时(如缺少list权限)按没有HPA处理
+func (kc *k8SClient) ChangeServicePod(target *Target, newCount *int32) error {
+	hpa, err := kc.resolver.HorizontalPodAutoscaler(target)
+	if err != nil {
+		log.Printf("WARN look up HPA of %s error %v, patch the scale subresource", target, err)
+	} else if hpa != nil {
+		return kc.changeHPAMinReplicas(hpa, target, *newCount)
+	}
+	gvr, err := kc.resource(target)
+	if err != nil {
+		return err
+	}
+	patch := []byte(fmt.Sprintf(`{"spec":{"replicas":%d}}`, *newCount))
+	return retry.OnError(changeBackoff, isRetriable, func() error {
+		_, err := kc.scales.Scales(target.Namespace).Patch(context.TODO(), gvr, target.Name,
+			types.MergePatchType, patch, metav1.PatchOptions{})
+		return err
+	})
+}
+
+func isRetriable(err error) bool {
+	return apierrors.IsConflict(err) ||
+		apierrors.IsServerTimeout(err) ||
+		apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServiceUnavailable(err)
+}
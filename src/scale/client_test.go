@@ -0,0 +1,148 @@
+package scale
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	fakescale "k8s.io/client-go/scale/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newFakeK8SClient(replicas map[string]int32, objects ...runtime.Object) (*k8SClient, *fakescale.FakeScaleClient) {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "Rollout"}, meta.RESTScopeNamespace)
+	scales := &fakescale.FakeScaleClient{}
+	scales.AddReactor("get", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		get := action.(k8stesting.GetAction)
+		key := get.GetResource().Resource + "/" + get.GetNamespace() + "/" + get.GetName()
+		return true, &autoscalingv1.Scale{
+			ObjectMeta: metav1.ObjectMeta{Name: get.GetName(), Namespace: get.GetNamespace()},
+			Spec:       autoscalingv1.ScaleSpec{Replicas: replicas[key]},
+		}, nil
+	})
+	scales.AddReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		s := &autoscalingv1.Scale{}
+		if err := json.Unmarshal(patch.GetPatch(), s); err != nil {
+			return true, nil, err
+		}
+		replicas[patch.GetResource().Resource+"/"+patch.GetNamespace()+"/"+patch.GetName()] = s.Spec.Replicas
+		return true, s, nil
+	})
+	clientset := fake.NewSimpleClientset(objects...)
+	dynamic := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		{Group: "apps", Version: "v1", Resource: "deployments"}:           "DeploymentList",
+		{Group: "apps", Version: "v1", Resource: "statefulsets"}:          "StatefulSetList",
+		{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts"}: "RolloutList",
+	})
+	return &k8SClient{clientset: clientset, dynamic: dynamic, mapper: mapper, scales: scales, resolver: NewResolver(clientset)}, scales
+}
+
+func TestK8SClient_GetServicePod(t *testing.T) {
+	replicas := map[string]int32{"statefulsets/demo/web": 3, "rollouts/demo/web": 5}
+	kc, _ := newFakeK8SClient(replicas)
+	cnt, err := kc.GetServicePod(NewTarget("apps/v1", "StatefulSet", "demo", "web"))
+	if err != nil || *cnt != 3 {
+		t.Fatalf("want 3, got %v %v", cnt, err)
+	}
+	cnt, err = kc.GetServicePod(NewTarget("argoproj.io/v1alpha1", "Rollout", "demo", "web"))
+	if err != nil || *cnt != 5 {
+		t.Fatalf("want 5, got %v %v", cnt, err)
+	}
+	if _, err = kc.GetServicePod(NewTarget("example.com/v1", "Unknown", "demo", "web")); err == nil {
+		t.Fatal("want no match error for unknown kind")
+	}
+}
+
+func TestK8SClient_ChangeServicePodDefaultKind(t *testing.T) {
+	replicas := map[string]int32{"deployments/demo/web": 1}
+	kc, _ := newFakeK8SClient(replicas)
+	newCount := int32(4)
+	if err := kc.ChangeServicePod(NewTarget("", "", "demo", "web"), &newCount); err != nil {
+		t.Fatal(err)
+	}
+	if replicas["deployments/demo/web"] != 4 {
+		t.Fatalf("want 4, got %d", replicas["deployments/demo/web"])
+	}
+}
+
+func TestK8SClient_ChangeServicePodRetryOnConflict(t *testing.T) {
+	kc, scales := newFakeK8SClient(map[string]int32{})
+	conflicts := 2
+	var patched string
+	scales.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts > 0 {
+			conflicts--
+			return true, nil, apierrors.NewConflict(schema.GroupResource{Group: "apps", Resource: "deployments"}, "web", nil)
+		}
+		patched = string(action.(k8stesting.PatchAction).GetPatch())
+		return true, &autoscalingv1.Scale{}, nil
+	})
+	newCount := int32(7)
+	if err := kc.ChangeServicePod(NewTarget("", "", "demo", "web"), &newCount); err != nil {
+		t.Fatal(err)
+	}
+	if conflicts != 0 || patched != `{"spec":{"replicas":7}}` {
+		t.Fatalf("conflicts left %d, patch %s", conflicts, patched)
+	}
+}
+
+func TestK8SClient_ChangeServicePodWithHPA(t *testing.T) {
+	minReplicas := int32(2)
+	hpa := &autoscalingv1.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-cpu", Namespace: "demo"},
+		Spec: autoscalingv1.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv1.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "web"},
+			MinReplicas:    &minReplicas,
+			MaxReplicas:    8,
+		},
+	}
+	replicas := map[string]int32{"deployments/demo/web": 2}
+	kc, _ := newFakeK8SClient(replicas, hpa)
+	newCount := int32(10)
+	if err := kc.ChangeServicePod(NewTarget("", "", "demo", "web"), &newCount); err != nil {
+		t.Fatal(err)
+	}
+	if replicas["deployments/demo/web"] != 2 {
+		t.Errorf("replicas should be left to the HPA, got %d", replicas["deployments/demo/web"])
+	}
+	got, err := kc.clientset.AutoscalingV1().HorizontalPodAutoscalers("demo").Get(context.TODO(), "web-cpu", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got.Spec.MinReplicas != 8 {
+		t.Errorf("want minReplicas bounded by maxReplicas 8, got %d", *got.Spec.MinReplicas)
+	}
+}
+
+func TestK8SClient_ChangeServicePodHPALookupFailed(t *testing.T) {
+	replicas := map[string]int32{"deployments/demo/web": 2}
+	kc, _ := newFakeK8SClient(replicas)
+	kc.clientset.(*fake.Clientset).PrependReactor("list", "horizontalpodautoscalers", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(action.GetResource().GroupResource(), "", fmt.Errorf("denied"))
+	})
+	kc.resolver.syncTimeout = time.Millisecond * 300
+	newCount := int32(5)
+	if err := kc.ChangeServicePod(NewTarget("", "", "demo", "web"), &newCount); err != nil {
+		t.Fatal(err)
+	}
+	if replicas["deployments/demo/web"] != 5 {
+		t.Fatalf("want scale subresource patched to 5, got %d", replicas["deployments/demo/web"])
+	}
+	if _, err := kc.resolver.cachedHorizontalPodAutoscaler(NewTarget("", "", "demo", "web")); err == nil {
+		t.Error("want cached lookup error without synced informers")
+	}
+}
@@ -0,0 +1,125 @@
+package scale
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultCluster scaleServices中没有指定cluster时使用
+const DefaultCluster = ""
+
+const healthTimeout = time.Second * 5
+
+// 集群的健康状态,1为健康
+var clusterHealthy = expvar.NewMap("cluster_healthy")
+
+// ClusterConfig 一个集群的连接方式,Kubeconfig和Context都为空时优先使用集群内的ServiceAccount
+type ClusterConfig struct {
+	Name       string
+	Kubeconfig string
+	Context    string
+}
+
+// registry 每个集群一个client,第一次使用时创建
+type registry struct {
+	mutex   sync.Mutex
+	configs map[string]ClusterConfig
+	clients map[string]*k8SClient
+	healthy map[string]bool
+}
+
+var clusters = &registry{
+	configs: map[string]ClusterConfig{DefaultCluster: {}},
+	clients: make(map[string]*k8SClient),
+	healthy: make(map[string]bool),
+}
+
+// AddCluster 注册集群,Name为空时修改默认集群。需要在第一次使用前调用
+func AddCluster(config ClusterConfig) {
+	clusters.mutex.Lock()
+	defer clusters.mutex.Unlock()
+	clusters.configs[config.Name] = config
+	delete(clusters.clients, config.Name)
+}
+
+// ClusterClient 取得集群的client
+func ClusterClient(name string) (*k8SClient, error) {
+	clusters.mutex.Lock()
+	defer clusters.mutex.Unlock()
+	if kc, ok := clusters.clients[name]; ok {
+		return kc, nil
+	}
+	config, ok := clusters.configs[name]
+	if !ok {
+		return nil, fmt.Errorf("cluster %s not configured", name)
+	}
+	restConfig, err := getConfig(config.Kubeconfig, config.Context)
+	if err != nil {
+		return nil, fmt.Errorf("cluster %s config error %w", clusterName(name), err)
+	}
+	kc, err := newK8SClient(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("cluster %s %w", clusterName(name), err)
+	}
+	clusters.clients[name] = kc
+	clusters.healthy[name] = true
+	return kc, nil
+}
+
+func clusterName(name string) string {
+	if name == DefaultCluster {
+		return "default"
+	}
+	return name
+}
+
+// CheckClusters 定时检查已经使用的集群的/healthz,状态变化时记录日志
+func CheckClusters(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		clusters.mutex.Lock()
+		clients := make(map[string]*k8SClient, len(clusters.clients))
+		for name, kc := range clusters.clients {
+			clients[name] = kc
+		}
+		clusters.mutex.Unlock()
+		for name, kc := range clients {
+			err := kc.Healthz()
+			clusters.mutex.Lock()
+			changed := clusters.healthy[name] != (err == nil)
+			clusters.healthy[name] = err == nil
+			clusters.mutex.Unlock()
+			v := new(expvar.Int)
+			if err == nil {
+				v.Set(1)
+			}
+			clusterHealthy.Set(clusterName(name), v)
+			if !changed {
+				continue
+			}
+			if err != nil {
+				log.Printf("WARN cluster %s unhealthy: %v", clusterName(name), err)
+			} else {
+				log.Printf("cluster %s healthy again", clusterName(name))
+			}
+		}
+	}
+}
+
+func (kc *k8SClient) Healthz() error {
+	ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+	defer cancel()
+	_, err := kc.clientset.Discovery().RESTClient().Get().AbsPath("/healthz").DoRaw(ctx)
+	return err
+}
@@ -0,0 +1,68 @@
+package scale
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: east
+  cluster:
+    server: %s
+- name: west
+  cluster:
+    server: https://west.example.com
+contexts:
+- name: east
+  context:
+    cluster: east
+    user: admin
+- name: west
+  context:
+    cluster: west
+    user: admin
+current-context: west
+users:
+- name: admin
+  user:
+    token: xxx
+`
+
+func TestClusterClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(kubeconfig, []byte(fmt.Sprintf(testKubeconfig, server.URL)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := getConfig(kubeconfig, "")
+	if err != nil || config.Host != "https://west.example.com" {
+		t.Fatalf("want current context west, got %v %v", config, err)
+	}
+	AddCluster(ClusterConfig{Name: "east", Kubeconfig: kubeconfig, Context: "east"})
+	kc, err := ClusterClient("east")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := ClusterClient("east"); again != kc {
+		t.Error("want the same client for the same cluster")
+	}
+	if err = kc.Healthz(); err != nil {
+		t.Errorf("want healthy, got %v", err)
+	}
+	if _, err = ClusterClient("north"); err == nil {
+		t.Error("want not configured error")
+	}
+}
@@ -0,0 +1,108 @@
+package scale
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ReplicaSet缩容时先删除cost小的Pod,需要Kubernetes 1.22+或开启PodDeletionCost
+const annotationDeletionCost = "controller.kubernetes.io/pod-deletion-cost"
+
+// DeletionCoster 缩容前按upstream最近的请求数设置Pod的deletion cost,空闲的Pod先被删除
+type DeletionCoster interface {
+	SetDeletionCosts(namespace, service string, load map[string]int) error
+}
+
+// SetDeletionCost 开启后缩容前设置pod-deletion-cost,Reason.Load为空时不设置
+func (sm *ScalerManage) SetDeletionCost(enabled bool) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.deletionCost = enabled
+}
+
+// SetDeletionCosts 通过Endpoints把upstream地址对应到Pod,所有Pod都会写入,没有请求的为0
+func (kc *k8SClient) SetDeletionCosts(namespace, service string, load map[string]int) error {
+	endpoints, err := kc.clientset.CoreV1().Endpoints(namespace).Get(context.TODO(), service, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	errs := make([]string, 0)
+	for pod, cost := range deletionCosts(endpoints, load) {
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]string{annotationDeletionCost: strconv.Itoa(cost)},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		_, err = kc.clientset.CoreV1().Pods(namespace).Patch(context.TODO(), pod, types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", pod, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("set pod deletion cost error %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// deletionCosts Pod名 -> cost,load的key为upstream地址,nginx重试时可能是逗号分隔的多个地址
+func deletionCosts(endpoints *corev1.Endpoints, load map[string]int) map[string]int {
+	byIP := make(map[string]int, len(load))
+	for upstream, n := range load {
+		for _, address := range strings.Split(upstream, ",") {
+			host := strings.TrimSpace(address)
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			byIP[host] += n
+		}
+	}
+	costs := make(map[string]int)
+	for _, subset := range endpoints.Subsets {
+		addresses := append(append([]corev1.EndpointAddress{}, subset.Addresses...), subset.NotReadyAddresses...)
+		for _, address := range addresses {
+			if address.TargetRef == nil || address.TargetRef.Kind != "Pod" {
+				continue
+			}
+			cost := byIP[address.IP]
+			if cost > math.MaxInt32 {
+				cost = math.MaxInt32
+			}
+			costs[address.TargetRef.Name] = cost
+		}
+	}
+	return costs
+}
+
+// setDeletionCosts 失败只记录日志,不影响缩容
+func (sm *ScalerManage) setDeletionCosts(serviceName string, reason *Reason) {
+	sm.mutex.Lock()
+	enabled := sm.deletionCost
+	sm.mutex.Unlock()
+	if !enabled || reason == nil || len(reason.Load) == 0 {
+		return
+	}
+	coster, ok := sm.backend(serviceName).(DeletionCoster)
+	if !ok {
+		return
+	}
+	names := strings.Split(serviceName, ".")
+	if len(names) != 2 {
+		return
+	}
+	if err := coster.SetDeletionCosts(names[1], names[0], reason.Load); err != nil {
+		log.Printf("%s %v", serviceName, err)
+	}
+}
@@ -0,0 +1,51 @@
+package scale
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestScalerManage_DeletionCost(t *testing.T) {
+	endpoints := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "demo"},
+		Subsets: []corev1.EndpointSubset{{
+			Addresses: []corev1.EndpointAddress{
+				{IP: "10.0.0.1", TargetRef: &corev1.ObjectReference{Kind: "Pod", Name: "web-a"}},
+				{IP: "10.0.0.2", TargetRef: &corev1.ObjectReference{Kind: "Pod", Name: "web-b"}},
+			},
+			NotReadyAddresses: []corev1.EndpointAddress{
+				{IP: "10.0.0.3", TargetRef: &corev1.ObjectReference{Kind: "Pod", Name: "web-c"}},
+			},
+		}},
+	}
+	pods := make([]*corev1.Pod, 0)
+	for _, name := range []string{"web-a", "web-b", "web-c"} {
+		pods = append(pods, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "demo"}})
+	}
+	replicas := map[string]int32{"deployments/demo/web": 3}
+	kc, _ := newFakeK8SClient(replicas, endpoints, pods[0], pods[1], pods[2])
+	kc.recorder = record.NewFakeRecorder(10)
+	sm := NewScaler(3, 60, kc)
+	sm.SetTargets("web.demo", []*Target{NewTarget("", "", "demo", "web")})
+	sm.SetDeletionCost(true)
+	newCount := int32(2)
+	// 重试时upstream_addr为逗号分隔的多个地址
+	load := map[string]int{"10.0.0.1:8080": 40, "10.0.0.2:8080, 10.0.0.1:8080": 3}
+	if _, err := sm.ChangeServicePod("web.demo", &newCount, &Reason{Load: load}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"web-a": "43", "web-b": "3", "web-c": "0"}
+	for name, cost := range want {
+		pod, err := kc.clientset.CoreV1().Pods("demo").Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := pod.Annotations[annotationDeletionCost]; got != cost {
+			t.Errorf("%s want cost %s, got %q", name, cost, got)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package scale
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	component   = "simple-hpa"
+	eventReason = "SimpleHPAScaled"
+
+	annotationPrefix        = "simple-hpa.io/"
+	annotationLastScaleTime = annotationPrefix + "last-scale-time"
+	annotationLastDecision  = annotationPrefix + "last-decision"
+	annotationObservedQps   = annotationPrefix + "observed-qps"
+)
+
+// Reason 触发伸缩时的观测值和阈值
+type Reason struct {
+	Qps     float32
+	MaxQps  float32
+	SafeQps float32
+	Message string         // 不是由QPS触发时的说明
+	Load    map[string]int // 每个upstream最近的请求数,缩容时用于设置pod-deletion-cost
+}
+
+func (r *Reason) String() string {
+	if r.Message != "" {
+		return r.Message
+	}
+	return fmt.Sprintf("qps=%.2f maxQps=%.2f safeQps=%.2f", r.Qps, r.MaxQps, r.SafeQps)
+}
+
+// ChangeRecorder 伸缩完成后在工作负载上留下记录
+type ChangeRecorder interface {
+	RecordChange(change *Change)
+}
+
+func newEventRecorder(clientset kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: component})
+}
+
+// RecordChange 在每个修改过的工作负载上记录Event和注解,kubectl describe即可看到
+func (kc *k8SClient) RecordChange(change *Change) {
+	for _, wc := range change.Workloads {
+		if wc.Old == wc.New {
+			continue
+		}
+		decision := "scale-up"
+		if wc.New < wc.Old {
+			decision = "scale-down"
+		}
+		msg := fmt.Sprintf("%s %s from %d to %d", decision, change.ServiceName, wc.Old, wc.New)
+		if change.Reason != nil {
+			msg = fmt.Sprintf("%s, %s", msg, change.Reason)
+		}
+		ref := &corev1.ObjectReference{
+			APIVersion: wc.Target.APIVersion,
+			Kind:       wc.Target.Kind,
+			Namespace:  wc.Target.Namespace,
+			Name:       wc.Target.Name,
+		}
+		kc.recorder.Event(ref, corev1.EventTypeNormal, eventReason, msg)
+		annotations := map[string]string{
+			annotationLastScaleTime: time.Now().Format(time.RFC3339),
+			annotationLastDecision:  fmt.Sprintf("%s from %d to %d", decision, wc.Old, wc.New),
+		}
+		if change.Reason != nil {
+			annotations[annotationObservedQps] = fmt.Sprintf("%.2f", change.Reason.Qps)
+		}
+		if err := kc.annotate(wc.Target, annotations); err != nil {
+			log.Printf("annotate %s error %v", wc.Target, err)
+		}
+	}
+}
+
+func (kc *k8SClient) annotate(target *Target, annotations map[string]string) error {
+	gvr, err := kc.resource(target)
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = kc.dynamic.Resource(gvr).Namespace(target.Namespace).Patch(context.TODO(), target.Name,
+		types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
@@ -0,0 +1,49 @@
+package scale
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestK8SClient_RecordChange(t *testing.T) {
+	deployment := &unstructured.Unstructured{}
+	deployment.SetAPIVersion("apps/v1")
+	deployment.SetKind("Deployment")
+	deployment.SetNamespace("demo")
+	deployment.SetName("web")
+	kc, _ := newFakeK8SClient(map[string]int32{})
+	kc.dynamic = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), deployment)
+	recorder := record.NewFakeRecorder(10)
+	kc.recorder = recorder
+
+	kc.RecordChange(&Change{
+		ServiceName: "web.demo",
+		Old:         2,
+		New:         4,
+		Workloads:   []*WorkloadChange{{Target: NewTarget("", "", "demo", "web"), Old: 2, New: 4}},
+		Reason:      &Reason{Qps: 21.5, MaxQps: 10, SafeQps: 5},
+	})
+	event := <-recorder.Events
+	if !strings.Contains(event, eventReason) || !strings.Contains(event, "scale-up web.demo from 2 to 4") ||
+		!strings.Contains(event, "qps=21.50") {
+		t.Errorf("unexpected event %s", event)
+	}
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	got, err := kc.dynamic.Resource(gvr).Namespace("demo").Get(context.TODO(), "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	annotations := got.GetAnnotations()
+	if annotations[annotationLastDecision] != "scale-up from 2 to 4" || annotations[annotationObservedQps] != "21.50" ||
+		annotations[annotationLastScaleTime] == "" {
+		t.Errorf("unexpected annotations %v", annotations)
+	}
+}
@@ -0,0 +1,237 @@
+package scale
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Group leader伸缩时followers按leader的期望副本数一起伸缩,followers的流量不经过ingress
+type Group struct {
+	Name      string
+	Leader    string
+	Followers []*Follower
+}
+
+// Follower 副本数为ceil(leader*Ratio)或Formula的结果,限制在[MinPod, MaxPod],MaxPod为0时不限制
+type Follower struct {
+	ServiceName string
+	Ratio       float64
+	Formula     *Formula
+	MinPod      int32
+	MaxPod      int32
+}
+
+func (f *Follower) Replicas(leader int32) int32 {
+	value := float64(leader) * f.Ratio
+	if f.Formula != nil {
+		value = f.Formula.Eval(float64(leader))
+	}
+	cnt := int32(math.Ceil(value))
+	if f.MaxPod > 0 && cnt > f.MaxPod {
+		cnt = f.MaxPod
+	}
+	if cnt < f.MinPod {
+		cnt = f.MinPod
+	}
+	return cnt
+}
+
+// SetGroup 设置以group.Leader为leader的分组,为nil时删除
+func (sm *ScalerManage) SetGroup(leader string, group *Group) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	if group == nil {
+		delete(sm.groups, leader)
+		return
+	}
+	sm.groups[leader] = group
+}
+
+// ChangeFollowers leader决定伸缩到leaderCnt后修改followers,不受followers自己的冷却时间限制,
+// 副本数计入预算。被暂停的follower跳过。一个follower失败不影响其他follower
+func (sm *ScalerManage) ChangeFollowers(leader string, leaderCnt int32, reason *Reason) ([]*Change, error) {
+	sm.mutex.Lock()
+	group, ok := sm.groups[leader]
+	// followers按leader的优先级参与预算分配
+	priority := 0
+	if d, ok := sm.demands[leader]; ok {
+		priority = d.priority
+	}
+	sm.mutex.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	changes := make([]*Change, 0, len(group.Followers))
+	errs := make([]string, 0)
+	for _, follower := range group.Followers {
+		sm.mutex.Lock()
+		held := sm.held(follower.ServiceName, time.Now())
+		sm.mutex.Unlock()
+		if held != "" {
+			log.Printf("skip %s follower %s: %s", group.Name, follower.ServiceName, held)
+			continue
+		}
+		cnt := follower.Replicas(leaderCnt)
+		if names := strings.Split(follower.ServiceName, "."); len(names) == 2 {
+			cnt = sm.Allocate(follower.ServiceName, names[1], priority, follower.MinPod, cnt)
+		}
+		followerReason := &Reason{Message: fmt.Sprintf("follow %s %d", leader, leaderCnt)}
+		if reason != nil {
+			followerReason.Qps, followerReason.MaxQps, followerReason.SafeQps = reason.Qps, reason.MaxQps, reason.SafeQps
+		}
+		change, err := sm.ChangeServicePod(follower.ServiceName, &cnt, followerReason)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if change != nil {
+			changes = append(changes, change)
+		}
+	}
+	if len(errs) > 0 {
+		return changes, fmt.Errorf("group %s: %s", group.Name, strings.Join(errs, "; "))
+	}
+	return changes, nil
+}
+
+// Formula 以leader为变量的四则运算表达式,如"leader / 3 + 1"
+type Formula struct {
+	expr string
+	eval func(leader float64) float64
+}
+
+func (f *Formula) Eval(leader float64) float64 {
+	return f.eval(leader)
+}
+
+func (f *Formula) String() string {
+	return f.expr
+}
+
+func ParseFormula(expr string) (*Formula, error) {
+	p := &formulaParser{expr: expr}
+	p.next()
+	eval, err := p.sum()
+	if err != nil {
+		return nil, fmt.Errorf("parse formula %q error %w", expr, err)
+	}
+	if p.token != "" {
+		return nil, fmt.Errorf("parse formula %q error unexpected %q", expr, p.token)
+	}
+	return &Formula{expr: expr, eval: eval}, nil
+}
+
+// formulaParser 递归下降: sum = product {(+|-) product}, product = factor {(*|/) factor},
+// factor = number | leader | (sum) | -factor
+type formulaParser struct {
+	expr  string
+	pos   int
+	token string
+}
+
+func (p *formulaParser) next() {
+	for p.pos < len(p.expr) && p.expr[p.pos] == ' ' {
+		p.pos++
+	}
+	if p.pos >= len(p.expr) {
+		p.token = ""
+		return
+	}
+	start := p.pos
+	switch c := p.expr[p.pos]; {
+	case c >= '0' && c <= '9' || c == '.':
+		for p.pos < len(p.expr) && (p.expr[p.pos] >= '0' && p.expr[p.pos] <= '9' || p.expr[p.pos] == '.') {
+			p.pos++
+		}
+	case c >= 'a' && c <= 'z':
+		for p.pos < len(p.expr) && p.expr[p.pos] >= 'a' && p.expr[p.pos] <= 'z' {
+			p.pos++
+		}
+	default:
+		p.pos++
+	}
+	p.token = p.expr[start:p.pos]
+}
+
+func (p *formulaParser) sum() (func(float64) float64, error) {
+	left, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	for p.token == "+" || p.token == "-" {
+		op := p.token
+		p.next()
+		right, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == "+" {
+			left = func(x float64) float64 { return l(x) + right(x) }
+		} else {
+			left = func(x float64) float64 { return l(x) - right(x) }
+		}
+	}
+	return left, nil
+}
+
+func (p *formulaParser) product() (func(float64) float64, error) {
+	left, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	for p.token == "*" || p.token == "/" {
+		op := p.token
+		p.next()
+		right, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == "*" {
+			left = func(x float64) float64 { return l(x) * right(x) }
+		} else {
+			left = func(x float64) float64 { return l(x) / right(x) }
+		}
+	}
+	return left, nil
+}
+
+func (p *formulaParser) factor() (func(float64) float64, error) {
+	token := p.token
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end")
+	case token == "leader":
+		p.next()
+		return func(x float64) float64 { return x }, nil
+	case token == "(":
+		p.next()
+		inner, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if p.token != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.next()
+		return inner, nil
+	case token == "-":
+		p.next()
+		inner, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return func(x float64) float64 { return -inner(x) }, nil
+	}
+	value, err := strconv.ParseFloat(token, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+	p.next()
+	return func(float64) float64 { return value }, nil
+}
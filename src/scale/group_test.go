@@ -0,0 +1,77 @@
+package scale
+
+import "testing"
+
+func TestParseFormula(t *testing.T) {
+	cases := map[string]float64{
+		"leader / 3 + 1":      5,
+		"2 * (leader - 3)":    18,
+		"-leader + 20":        8,
+		"leader*0.5+leader/4": 9,
+	}
+	for expr, want := range cases {
+		formula, err := ParseFormula(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := formula.Eval(12); got != want {
+			t.Errorf("%s want %v, got %v", expr, want, got)
+		}
+	}
+	for _, expr := range []string{"leader +", "(leader", "replicas * 2", "leader 2"} {
+		if _, err := ParseFormula(expr); err == nil {
+			t.Errorf("%s want error", expr)
+		}
+	}
+}
+
+func TestScalerManage_ChangeFollowers(t *testing.T) {
+	client := &stubScaler{replicas: map[string]int32{"api": 2, "worker": 1, "cache": 3}}
+	sm := NewScaler(3, 60, client)
+	formula, _ := ParseFormula("leader / 3 + 1")
+	sm.SetGroup("web.shop", &Group{Name: "shop", Leader: "web.shop", Followers: []*Follower{
+		{ServiceName: "api.shop", Ratio: 0.5, MinPod: 1, MaxPod: 4},
+		{ServiceName: "worker.shop", Formula: formula, MinPod: 1},
+		{ServiceName: "cache.shop", Ratio: 1, MinPod: 1},
+	}})
+	sm.Pause("cache.shop", nil)
+	changes, err := sm.ChangeFollowers("web.shop", 10, &Reason{Qps: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || client.replicas["api"] != 4 || client.replicas["worker"] != 5 {
+		t.Fatalf("want api capped to 4 and worker 5, got %v %v", changes, client.replicas)
+	}
+	if client.replicas["cache"] != 3 {
+		t.Errorf("paused follower should not change, got %d", client.replicas["cache"])
+	}
+	// 已经是期望值时不再修改
+	if changes, _ = sm.ChangeFollowers("web.shop", 10, nil); len(changes) != 0 {
+		t.Errorf("want no changes, got %v", changes)
+	}
+	if changes, _ = sm.ChangeFollowers("api.shop", 10, nil); changes != nil {
+		t.Errorf("api.shop leads no group, got %v", changes)
+	}
+}
+
+func TestScalerManage_ChangeFollowersBudget(t *testing.T) {
+	client := &stubScaler{replicas: map[string]int32{"api": 1}}
+	sm := NewScaler(3, 60, client)
+	sm.SetBudget(&Budget{Namespaces: map[string]BudgetLimit{"shop": {MaxPods: 10}}})
+	sm.SetGroup("web.shop", &Group{Name: "shop", Leader: "web.shop", Followers: []*Follower{
+		{ServiceName: "api.shop", Ratio: 1, MinPod: 1},
+	}})
+	if got := sm.Allocate("web.shop", "shop", 0, 1, 8); got != 8 {
+		t.Fatalf("want leader 8, got %d", got)
+	}
+	// follower也要8个,同优先级按比例分配10个
+	if _, err := sm.ChangeFollowers("web.shop", 8, nil); err != nil {
+		t.Fatal(err)
+	}
+	if client.replicas["api"] != 5 {
+		t.Fatalf("want follower limited by budget to 5, got %d", client.replicas["api"])
+	}
+	if got := sm.Allocate("web.shop", "shop", 0, 1, 8); got != 5 {
+		t.Fatalf("want leader 5 with follower charged, got %d", got)
+	}
+}
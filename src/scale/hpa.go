@@ -0,0 +1,82 @@
+package scale
+
+import (
+	"fmt"
+	"log"
+
+	"golang.org/x/net/context"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+)
+
+// HorizontalPodAutoscaler 找到以target为伸缩对象的原生HPA,没有时返回nil
+func (r *Resolver) HorizontalPodAutoscaler(target *Target) (*autoscalingv1.HorizontalPodAutoscaler, error) {
+	nl, err := r.namespace(target.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	return findHPA(nl, target)
+}
+
+// cachedHorizontalPodAutoscaler 只查询已经同步的缓存,不等待informer,用于informer的回调中
+func (r *Resolver) cachedHorizontalPodAutoscaler(target *Target) (*autoscalingv1.HorizontalPodAutoscaler, error) {
+	r.mutex.Lock()
+	nl, ok := r.listers[target.Namespace]
+	r.mutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("namespace %s informer cache not started", target.Namespace)
+	}
+	for _, synced := range nl.synced {
+		if !synced() {
+			return nil, fmt.Errorf("namespace %s informer cache not synced", target.Namespace)
+		}
+	}
+	return findHPA(nl, target)
+}
+
+func findHPA(nl *namespaceListers, target *Target) (*autoscalingv1.HorizontalPodAutoscaler, error) {
+	hpas, err := nl.hpas.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	gv, err := schema.ParseGroupVersion(target.APIVersion)
+	if err != nil {
+		return nil, err
+	}
+	for _, hpa := range hpas {
+		ref := hpa.Spec.ScaleTargetRef
+		refGV, err := schema.ParseGroupVersion(ref.APIVersion)
+		if err != nil {
+			continue
+		}
+		if ref.Kind == target.Kind && ref.Name == target.Name && refGV.Group == gv.Group {
+			return hpa, nil
+		}
+	}
+	return nil, nil
+}
+
+// changeHPAMinReplicas 工作负载已经被HPA管理时,直接改副本数会被HPA改回去。
+// 改为修改HPA的minReplicas,CPU和流量两个信号取大的一个
+func (kc *k8SClient) changeHPAMinReplicas(hpa *autoscalingv1.HorizontalPodAutoscaler, target *Target, newCount int32) error {
+	minReplicas := newCount
+	if minReplicas > hpa.Spec.MaxReplicas {
+		log.Printf("WARN %s wants %d, but HPA %s maxReplicas is %d", target, newCount, hpa.Name, hpa.Spec.MaxReplicas)
+		minReplicas = hpa.Spec.MaxReplicas
+	}
+	if minReplicas < 1 {
+		minReplicas = 1
+	}
+	log.Printf("WARN %s is managed by HPA %s, change its minReplicas to %d instead of replicas",
+		target, hpa.Name, minReplicas)
+	patch := []byte(fmt.Sprintf(`{"spec":{"minReplicas":%d}}`, minReplicas))
+	return retry.OnError(changeBackoff, isRetriable, func() error {
+		_, err := kc.clientset.AutoscalingV1().HorizontalPodAutoscalers(hpa.Namespace).Patch(context.TODO(),
+			hpa.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		return err
+	})
+}
@@ -1,95 +1,13 @@
 package scale
 
 import (
+	"fmt"
 	"log"
-	"path/filepath"
 	"strings"
+	"sync"
 	"time"
-
-	"golang.org/x/net/context"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
 )
 
-var client *k8SClient
-
-type Scaler interface {
-	GetServicePod(namespace, service string) (*int32, error)
-	ChangeServicePod(namespace, service string, newCount *int32) error
-}
-
-func getClient() (*kubernetes.Clientset, error) {
-	client, err := getClientOutCluster()
-	if err == nil {
-		log.Println("there is a kube file,guess outside the cluster")
-		return client, nil
-	}
-	log.Println("guess inside the cluster")
-	return getClientInCluster()
-}
-
-func getClientOutCluster() (*kubernetes.Clientset, error) {
-	var kubeConfigFile string
-	homePath := homedir.HomeDir()
-	if homePath != "" {
-		kubeConfigFile = filepath.Join(homePath, ".kube", "config")
-	}
-	config, err := clientcmd.BuildConfigFromFlags("", kubeConfigFile)
-	if err != nil {
-		return nil, err
-	}
-	clientSet, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		log.Fatalln(err)
-		return nil, err
-	}
-	return clientSet, nil
-}
-
-func getClientInCluster() (*kubernetes.Clientset, error) {
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		return nil, err
-	}
-	return kubernetes.NewForConfig(config)
-}
-
-func newK8SClient() *k8SClient {
-	if client != nil {
-		return client
-	}
-	clientset, err := getClient()
-	if err != nil {
-		log.Fatalln("init client failed")
-	}
-	return &k8SClient{clientset: clientset}
-}
-
-type k8SClient struct {
-	clientset *kubernetes.Clientset
-}
-
-func (kc *k8SClient) GetServicePod(namespace, service string) (*int32, error) {
-	dep, err := kc.clientset.AppsV1().Deployments(namespace).Get(context.TODO(), service, metav1.GetOptions{})
-	if err != nil {
-		return nil, err
-	}
-	return dep.Spec.Replicas, nil
-}
-
-func (kc *k8SClient) ChangeServicePod(namespace, service string, newCount *int32) error {
-	dep, err := kc.clientset.AppsV1().Deployments(namespace).Get(context.TODO(), service, metav1.GetOptions{})
-	if err != nil {
-		return err
-	}
-	dep.Spec.Replicas = newCount
-	_, err = kc.clientset.AppsV1().Deployments(namespace).Update(context.TODO(), dep, metav1.UpdateOptions{})
-	return err
-}
-
 func newOks(c int) *oks {
 	return &oks{data: make([]bool, c, c), i: 0}
 }
@@ -122,28 +40,154 @@ func (o *oks) allTrue() bool {
 	return true
 }
 
-func NewScaler(cnt, internal int) *ScalerManage {
+func NewScaler(cnt, internal int, client Scaler) *ScalerManage {
 	r := &ScalerManage{
-		cnt:       cnt,
-		interval:  time.Second * time.Duration(internal),
-		histories: make(map[string]time.Time),
-		client:    newK8SClient(),
-		safes:     make(map[string]*oks),
-		wastes:    make(map[string]*oks),
+		cnt:             cnt,
+		interval:        time.Second * time.Duration(internal),
+		histories:       make(map[string]time.Time),
+		client:          client,
+		safes:           make(map[string]*oks),
+		wastes:          make(map[string]*oks),
+		targets:         make(map[string][]*Target),
+		resolved:        make(map[string][]*Target),
+		dryRuns:         make(map[string]bool),
+		overrides:       make(map[string]*Override),
+		expected:        make(map[string]int32),
+		watched:         make(map[string]bool),
+		backends:        make(map[string]Scaler),
+		shadows:         make(map[string]*shadowScaler),
+		demands:         make(map[string]*demand),
+		starving:        make(map[string]bool),
+		groups:          make(map[string]*Group),
+		baselines:       make(map[string]*Signal),
+		regressions:     make(map[string]*regressionWatch),
+		suppressed:      make(map[string]time.Time),
+		resourceTargets: make(map[string]ResourceTargets),
+		shadow:          newShadowScaler(client),
 	}
 	return r
 }
 
 type ScalerManage struct {
-	cnt       int
-	interval  time.Duration
-	histories map[string]time.Time // 历史操作记录
-	safes     map[string]*oks
-	wastes    map[string]*oks
-	client    *k8SClient
+	mutex           sync.Mutex
+	cnt             int
+	interval        time.Duration
+	histories       map[string]time.Time // 历史操作记录
+	safes           map[string]*oks
+	wastes          map[string]*oks
+	targets         map[string][]*Target // 配置中指定的伸缩目标(targetRefs)
+	resolved        map[string][]*Target // 最近一次通过Service selector解析到的目标
+	dryRuns         map[string]bool      // 试运行的服务,只记录不修改
+	overrides       map[string]*Override // 管理接口设置的暂停、固定副本数等,key为服务名或AllServices
+	expected        map[string]int32     // 每个工作负载最近一次写入或观察到的副本数,key为Target.String()
+	watched         map[string]bool      // 已经监听副本数的工作负载
+	manualPolicy    string
+	manualGrace     time.Duration
+	manualActive    func() bool
+	notify          func(msg string)
+	client          Scaler
+	shadow          *shadowScaler
+	backends        map[string]Scaler        // 不使用Kubernetes的服务
+	shadows         map[string]*shadowScaler // backends试运行时使用
+	budget          *Budget
+	demands         map[string]*demand // 参与预算分配的服务
+	starving        map[string]bool
+	groups          map[string]*Group // key为leader
+	deletionCost    bool
+	regression      *RegressionPolicy
+	baselines       map[string]*Signal          // 缩容前的请求结果
+	regressions     map[string]*regressionWatch // 缩容后观察中的服务
+	suppressed      map[string]time.Time        // 回滚后暂停缩容的截止时间
+	resourceTargets map[string]ResourceTargets  // CPU、内存的目标利用率
+}
+
+// SetNotify 人工修改、资源不足等不经过handler的情况通过notify通知
+func (sm *ScalerManage) SetNotify(notify func(msg string)) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.notify = notify
+}
+
+func (sm *ScalerManage) SetDryRun(serviceName string, dryRun bool) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.dryRuns[serviceName] = dryRun
+}
+
+func (sm *ScalerManage) SetTargets(serviceName string, targets []*Target) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.targets[serviceName] = targets
+}
+
+// RemoveService 服务不再自动伸缩时清理它的所有状态
+func (sm *ScalerManage) RemoveService(serviceName string) {
+	watcher, ok := sm.backend(serviceName).(ReplicaWatcher)
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	// 先清理监听,再删除targets和resolved
+	for _, target := range sm.unwatch(serviceName) {
+		if ok {
+			watcher.UnwatchReplicas(target)
+		}
+	}
+	delete(sm.histories, serviceName)
+	delete(sm.safes, serviceName)
+	delete(sm.wastes, serviceName)
+	delete(sm.targets, serviceName)
+	delete(sm.resolved, serviceName)
+	delete(sm.dryRuns, serviceName)
+	delete(sm.overrides, serviceName)
+	delete(sm.backends, serviceName)
+	delete(sm.shadows, serviceName)
+	delete(sm.demands, serviceName)
+	delete(sm.starving, serviceName)
+	delete(sm.groups, serviceName)
+	delete(sm.baselines, serviceName)
+	delete(sm.regressions, serviceName)
+	delete(sm.suppressed, serviceName)
+	delete(sm.resourceTargets, serviceName)
+}
+
+// resolve 优先使用配置的targetRef,其次通过Service selector解析,都没有时使用同名Deployment
+func (sm *ScalerManage) resolve(serviceName string) ([]*Target, error) {
+	sm.mutex.Lock()
+	targets, ok := sm.targets[serviceName]
+	sm.mutex.Unlock()
+	if ok && len(targets) > 0 {
+		sm.watch(serviceName, targets)
+		return targets, nil
+	}
+	namespaces := strings.Split(serviceName, ".")
+	if len(namespaces) != 2 {
+		return nil, fmt.Errorf("%s no valid serviceName, use format like svc.namespace", serviceName)
+	}
+	namespace, service := namespaces[1], namespaces[0]
+	if resolver, ok := sm.backend(serviceName).(TargetResolver); ok {
+		targets, err := resolver.ResolveTargets(namespace, service)
+		if err == nil {
+			sm.mutex.Lock()
+			sm.resolved[serviceName] = targets
+			sm.mutex.Unlock()
+			sm.watch(serviceName, targets)
+			return targets, nil
+		}
+		sm.mutex.Lock()
+		cached, ok := sm.resolved[serviceName]
+		sm.mutex.Unlock()
+		if ok {
+			return cached, nil
+		}
+		log.Printf("resolve %s workload failed, use Deployment %s: %v", serviceName, service, err)
+	}
+	targets = []*Target{NewTarget(DefaultAPIVersion, DefaultKind, namespace, service)}
+	sm.watch(serviceName, targets)
+	return targets, nil
 }
 
 func (sm *ScalerManage) Update(k string, isSafe, isWaste bool) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
 	if val, ok := sm.safes[k]; !ok {
 		sm.safes[k] = newOks(sm.cnt)
 		sm.safes[k].insert(isSafe)
@@ -158,7 +202,17 @@ func (sm *ScalerManage) Update(k string, isSafe, isWaste bool) {
 	}
 }
 
+// NeedChange 发布中的服务跳过判断,等新Pod可用后再决定
 func (sm *ScalerManage) NeedChange(serviceName string) bool {
+	if !sm.needChange(serviceName) {
+		return false
+	}
+	return !sm.rolloutInProgress(serviceName)
+}
+
+func (sm *ScalerManage) needChange(serviceName string) bool {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
 	latest, ok := sm.histories[serviceName]
 	if !ok {
 		sm.histories[serviceName] = time.Now().Add(sm.interval)
@@ -167,6 +221,9 @@ func (sm *ScalerManage) NeedChange(serviceName string) bool {
 	if latest.After(time.Now()) {
 		return false
 	}
+	if sm.held(serviceName, time.Now()) != "" {
+		return false
+	}
 	return sm.isWaste(serviceName) || sm.isDanger(serviceName)
 }
 
@@ -180,25 +237,191 @@ func (sm *ScalerManage) isWaste(serviceName string) bool {
 	return sm.wastes[serviceName].allTrue()
 }
 
-func (sm *ScalerManage) ChangeServicePod(serviceName string, newCnt *int32) *int32 {
-	namespaces := strings.Split(serviceName, ".")
-	if len(namespaces) != 2 {
-		log.Fatalln(serviceName, "no valid serviceName, use format like svc.namespace")
+func (sm *ScalerManage) setExpected(target *Target, replicas int32) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.expected[target.String()] = replicas
+}
+
+// scaler 试运行的服务使用模拟的副本数
+func (sm *ScalerManage) scaler(serviceName string) (Scaler, bool) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	backend, ok := sm.backends[serviceName]
+	if sm.dryRuns[serviceName] {
+		if ok {
+			return sm.shadows[serviceName], true
+		}
+		return sm.shadow, true
 	}
-	namespace, service := namespaces[1], namespaces[0]
-	oldCnt, err := sm.client.GetServicePod(namespace, service)
+	if ok {
+		return backend, false
+	}
+	return sm.client, false
+}
+
+// Replicas 服务所有伸缩目标当前的副本总数,试运行的服务为模拟的副本数
+func (sm *ScalerManage) Replicas(serviceName string) (int32, error) {
+	targets, err := sm.resolve(serviceName)
 	if err != nil {
-		log.Println("get ", serviceName, "pod error", err)
-		return nil
+		return 0, err
 	}
-	if *oldCnt == *newCnt {
-		return nil
+	scaler, _ := sm.scaler(serviceName)
+	var total int32
+	for _, target := range targets {
+		cnt, err := scaler.GetServicePod(target)
+		if err != nil {
+			return 0, fmt.Errorf("get %s(%s) pod error: %w", serviceName, target, err)
+		}
+		total += *cnt
 	}
-	log.Printf("change %s from %d to %d", serviceName, *oldCnt, *newCnt)
-	err = sm.client.ChangeServicePod(namespace, service, newCnt)
-	sm.histories[serviceName] = time.Now().Add(sm.interval)
+	return total, nil
+}
+
+// Wake 缩到0的服务收到请求时立即扩到minActive,不受冷却时间限制。副本数不为0或被暂停时返回nil
+func (sm *ScalerManage) Wake(serviceName string, minActive int32) (*Change, error) {
+	sm.mutex.Lock()
+	held := sm.held(serviceName, time.Now())
+	sm.mutex.Unlock()
+	if held != "" {
+		return nil, nil
+	}
+	targets, err := sm.resolve(serviceName)
 	if err != nil {
-		log.Println("change service pod error", err)
+		return nil, err
+	}
+	scaler, _ := sm.scaler(serviceName)
+	for _, target := range targets {
+		cnt, err := scaler.GetServicePod(target)
+		if err != nil {
+			return nil, fmt.Errorf("get %s(%s) pod error: %w", serviceName, target, err)
+		}
+		if *cnt > 0 {
+			return nil, nil
+		}
+	}
+	return sm.ChangeServicePod(serviceName, &minActive, &Reason{Message: "wake up on request"})
+}
+
+// ChangeServicePod 将Service的副本总数改为newCnt,多个工作负载时按权重或当前副本数的比例分配。
+// 无需修改时返回nil。修改失败不会进入冷却时间,下次判断时会再尝试
+func (sm *ScalerManage) ChangeServicePod(serviceName string, newCnt *int32, reason *Reason) (*Change, error) {
+	targets, err := sm.resolve(serviceName)
+	if err != nil {
+		scaleFailed.Add(serviceName, 1)
+		return nil, err
+	}
+	scaler, dryRun := sm.scaler(serviceName)
+	change := &Change{
+		ServiceName: serviceName,
+		New:         *newCnt,
+		Workloads:   make([]*WorkloadChange, len(targets)),
+		DryRun:      dryRun,
+		Reason:      reason,
+	}
+	weights := make([]float64, len(targets))
+	byWeight := false
+	for i, target := range targets {
+		oldCnt, err := scaler.GetServicePod(target)
+		if err != nil {
+			scaleFailed.Add(serviceName, 1)
+			return nil, fmt.Errorf("get %s(%s) pod error: %w", serviceName, target, err)
+		}
+		change.Old += *oldCnt
+		change.Workloads[i] = &WorkloadChange{Target: target, Old: *oldCnt}
+		weights[i] = float64(*oldCnt)
+		byWeight = byWeight || target.Weight > 0
+	}
+	if change.Old == change.New {
+		return nil, nil
+	}
+	sm.mutex.Lock()
+	// 管理接口固定的副本数不受回滚后暂停缩容的限制
+	o, ok := sm.overrides[serviceName]
+	pinned := ok && o.Pin != nil && *o.Pin == change.New
+	suppressed := change.New < change.Old && !pinned && sm.scaleDownSuppressed(serviceName, time.Now())
+	sm.mutex.Unlock()
+	if suppressed {
+		log.Printf("%s scale-down to %d suppressed after a rollback", serviceName, change.New)
+		return nil, nil
+	}
+	if byWeight {
+		for i, target := range targets {
+			weights[i] = target.Weight
+		}
+	}
+	for i, cnt := range split(change.New, weights) {
+		change.Workloads[i].New = cnt
+	}
+	if change.Shortfall = sm.capacity(serviceName, change); change.Shortfall != "" && change.Old == change.New {
+		scaleFailed.Add(serviceName, 1)
+		return nil, fmt.Errorf("%s can not scale up: %s", serviceName, change.Shortfall)
+	}
+	log.Printf("change %s", change)
+	if change.New < change.Old && !dryRun {
+		// 让ReplicaSet先删除空闲的Pod
+		sm.setDeletionCosts(serviceName, reason)
+	}
+	applied := make([]*WorkloadChange, 0, len(change.Workloads))
+	for _, wc := range change.Workloads {
+		if wc.Old == wc.New {
+			continue
+		}
+		cnt := wc.New
+		if !dryRun {
+			// 先记下,避免把自己的修改当成人工修改
+			sm.setExpected(wc.Target, wc.New)
+		}
+		if err = scaler.ChangeServicePod(wc.Target, &cnt); err != nil {
+			if !dryRun {
+				sm.setExpected(wc.Target, wc.Old)
+			}
+			scaleFailed.Add(serviceName, 1)
+			err = fmt.Errorf("change %s(%s) pod error: %w", serviceName, wc.Target, err)
+			if rollbackErr := sm.rollbackWorkloads(scaler, applied, dryRun); rollbackErr != nil {
+				err = fmt.Errorf("%v, rollback error: %v", err, rollbackErr)
+			}
+			return nil, err
+		}
+		applied = append(applied, wc)
+	}
+	if change.DryRun {
+		dryRunChanges.Add(serviceName, 1)
+	} else {
+		scaleSucceeded.Add(serviceName, 1)
+		if recorder, ok := sm.backend(serviceName).(ChangeRecorder); ok {
+			recorder.RecordChange(change)
+		}
+		if change.New > change.Old {
+			go sm.reportPending(serviceName, change)
+		} else {
+			sm.watchRegression(serviceName, change)
+		}
+	}
+	sm.mutex.Lock()
+	sm.histories[serviceName] = time.Now().Add(sm.interval)
+	sm.mutex.Unlock()
+	return change, nil
+}
+
+// rollbackWorkloads 部分工作负载修改失败时,把已经修改的改回原来的副本数,保持各工作负载的比例
+func (sm *ScalerManage) rollbackWorkloads(scaler Scaler, applied []*WorkloadChange, dryRun bool) error {
+	var errs []string
+	for i := len(applied) - 1; i >= 0; i-- {
+		wc := applied[i]
+		cnt := wc.Old
+		if !dryRun {
+			sm.setExpected(wc.Target, wc.Old)
+		}
+		if err := scaler.ChangeServicePod(wc.Target, &cnt); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", wc.Target, err))
+			if !dryRun {
+				sm.setExpected(wc.Target, wc.New)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
 	}
-	return oldCnt
+	return nil
 }
@@ -1,15 +1,17 @@
 package scale
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"testing"
+	"time"
 )
 
 func TestK8SClient_ChangeServicePod(t *testing.T) {
 	client := NewK8SClient()
 	newCount := int32(1)
-	err := client.ChangeServicePod("demo-dev", "daohao", &newCount)
+	err := client.ChangeServicePod(NewTarget("", "", "demo-dev", "daohao"), &newCount)
 	log.Println(err)
 }
 
@@ -20,4 +22,169 @@ func TestScalerManage_Update(t *testing.T) {
 	log.Println(err)
 	log.Println("names", namespace)
 	log.Println("svc", svc)
-}
\ No newline at end of file
+}
+
+type stubScaler struct {
+	replicas map[string]int32
+	err      error
+	errs     map[string]error // 只对指定的工作负载返回错误
+}
+
+func (s *stubScaler) GetServicePod(target *Target) (*int32, error) {
+	cnt := s.replicas[target.Name]
+	return &cnt, nil
+}
+
+func (s *stubScaler) ChangeServicePod(target *Target, newCount *int32) error {
+	if s.err != nil {
+		return s.err
+	}
+	if err := s.errs[target.Name]; err != nil {
+		return err
+	}
+	s.replicas[target.Name] = *newCount
+	return nil
+}
+
+func TestScalerManage_ChangeServicePodFailed(t *testing.T) {
+	client := &stubScaler{replicas: map[string]int32{"web": 2}, err: errors.New("forbidden")}
+	sm := NewScaler(3, 60, client)
+	newCount := int32(4)
+	if _, err := sm.ChangeServicePod("web.demo", &newCount, nil); err == nil {
+		t.Fatal("want error")
+	}
+	if _, ok := sm.histories["web.demo"]; ok {
+		t.Fatal("failed change should not start the cooldown")
+	}
+	client.err = nil
+	change, err := sm.ChangeServicePod("web.demo", &newCount, nil)
+	if err != nil || change.Old != 2 || client.replicas["web"] != 4 {
+		t.Fatalf("want 2 -> 4, got %v %v", change, err)
+	}
+	if sm.histories["web.demo"].Before(time.Now()) {
+		t.Fatal("successful change should start the cooldown")
+	}
+}
+
+func TestScalerManage_ChangeServicePodProportional(t *testing.T) {
+	client := &stubScaler{replicas: map[string]int32{"web-stable": 3, "web-canary": 1}}
+	sm := NewScaler(3, 60, client)
+	sm.SetTargets("web.demo", []*Target{NewTarget("", "", "demo", "web-stable"), NewTarget("", "", "demo", "web-canary")})
+	newCount := int32(8)
+	change, err := sm.ChangeServicePod("web.demo", &newCount, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.replicas["web-stable"] != 6 || client.replicas["web-canary"] != 2 {
+		t.Fatalf("want 6/2, got %v", client.replicas)
+	}
+	log.Println(change)
+
+	stable, canary := NewTarget("", "", "demo", "web-stable"), NewTarget("", "", "demo", "web-canary")
+	stable.Weight, canary.Weight = 9, 1
+	sm.SetTargets("web.demo", []*Target{stable, canary})
+	newCount = 3
+	if _, err = sm.ChangeServicePod("web.demo", &newCount, nil); err != nil {
+		t.Fatal(err)
+	}
+	if client.replicas["web-stable"] != 2 || client.replicas["web-canary"] != 1 {
+		t.Fatalf("want 2/1, got %v", client.replicas)
+	}
+}
+
+func TestScalerManage_ChangeServicePodPartialFailed(t *testing.T) {
+	client := &stubScaler{
+		replicas: map[string]int32{"web-stable": 3, "web-canary": 1},
+		errs:     map[string]error{"web-canary": errors.New("forbidden")},
+	}
+	sm := NewScaler(3, 60, client)
+	sm.SetTargets("web.demo", []*Target{NewTarget("", "", "demo", "web-stable"), NewTarget("", "", "demo", "web-canary")})
+	newCount := int32(8)
+	change, err := sm.ChangeServicePod("web.demo", &newCount, nil)
+	if err == nil || change != nil {
+		t.Fatalf("want error without change, got %v %v", change, err)
+	}
+	// 第一个工作负载已经改成6,要改回3
+	if client.replicas["web-stable"] != 3 || client.replicas["web-canary"] != 1 {
+		t.Fatalf("want rolled back to 3/1, got %v", client.replicas)
+	}
+	if expected := sm.expected[NewTarget("", "", "demo", "web-stable").String()]; expected != 3 {
+		t.Errorf("want expected replicas 3 after rollback, got %d", expected)
+	}
+	if _, ok := sm.histories["web.demo"]; ok {
+		t.Error("failed change should not start the cooldown")
+	}
+}
+
+func TestSplit(t *testing.T) {
+	cases := []struct {
+		total   int32
+		weights []float64
+		want    []int32
+	}{
+		{10, []float64{1, 1}, []int32{5, 5}},
+		{7, []float64{2, 1}, []int32{5, 2}},
+		{5, []float64{0, 0, 0}, []int32{2, 2, 1}},
+		{10, []float64{9, 1}, []int32{9, 1}},
+		{2, []float64{99, 1}, []int32{1, 1}},
+		{1, []float64{99, 1}, []int32{1, 0}},
+	}
+	for _, c := range cases {
+		got := split(c.total, c.weights)
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("split(%d, %v) = %v, want %v", c.total, c.weights, got, c.want)
+		}
+	}
+}
+
+func TestScalerManage_ChangeServicePodDryRun(t *testing.T) {
+	client := &stubScaler{replicas: map[string]int32{"web": 2}}
+	sm := NewScaler(3, 0, client)
+	sm.SetDryRun("web.demo", true)
+	newCount := int32(5)
+	change, err := sm.ChangeServicePod("web.demo", &newCount, nil)
+	if err != nil || !change.DryRun || change.Old != 2 {
+		t.Fatalf("want dry-run 2 -> 5, got %v %v", change, err)
+	}
+	if client.replicas["web"] != 2 {
+		t.Fatalf("dry-run changed real replicas to %d", client.replicas["web"])
+	}
+	// 第二次决策基于模拟的副本数
+	newCount = 3
+	change, err = sm.ChangeServicePod("web.demo", &newCount, nil)
+	if err != nil || change.Old != 5 {
+		t.Fatalf("want dry-run 5 -> 3, got %v %v", change, err)
+	}
+	if cnt, err := sm.Replicas("web.demo"); err != nil || cnt != 3 {
+		t.Fatalf("want simulated replicas 3, got %d %v", cnt, err)
+	}
+	log.Println(change)
+}
+
+type failingResolver struct {
+	stubScaler
+}
+
+func (f *failingResolver) ResolveTargets(namespace, service string) ([]*Target, error) {
+	return nil, errors.New("service has no selector")
+}
+
+func TestScalerManage_ResolveFailed(t *testing.T) {
+	client := &failingResolver{stubScaler{replicas: map[string]int32{"web": 0}}}
+	sm := NewScaler(3, 0, client)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		newCount := int32(2)
+		// 解析失败时使用同名Deployment
+		change, err := sm.ChangeServicePod("web.demo", &newCount, nil)
+		if err != nil || change.Old != 0 || client.replicas["web"] != 2 {
+			t.Errorf("want 0 -> 2, got %v %v", change, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("resolve deadlocked")
+	}
+}
@@ -0,0 +1,244 @@
+package scale
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// 发现人工修改副本数后的处理方式
+const (
+	ManualHold   = "hold"   // 在宽限期内不自动伸缩
+	ManualFloor  = "floor"  // 人工设置的副本数作为新的下限
+	ManualIgnore = "ignore" // 不处理,下次判断时可能被覆盖
+)
+
+// ReplicaWatcher 监听工作负载的副本数,启动时和每次变化时回调
+type ReplicaWatcher interface {
+	WatchReplicas(target *Target, onChange func(replicas int32)) error
+	UnwatchReplicas(target *Target)
+}
+
+// replicaWatch 同一namespace下同一种工作负载共用一个informer
+type replicaWatch struct {
+	mutex    sync.RWMutex
+	handlers map[string]func(replicas int32) // key为Target.String()
+}
+
+func (rw *replicaWatch) dispatch(obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	replicas, found, _ := unstructured.NestedInt64(u.Object, "spec", "replicas")
+	if !found {
+		return
+	}
+	key := NewTarget(u.GetAPIVersion(), u.GetKind(), u.GetNamespace(), u.GetName()).String()
+	rw.mutex.RLock()
+	handler, ok := rw.handlers[key]
+	rw.mutex.RUnlock()
+	if ok {
+		handler(int32(replicas))
+	}
+}
+
+func (kc *k8SClient) WatchReplicas(target *Target, onChange func(replicas int32)) error {
+	gvr, err := kc.resource(target)
+	if err != nil {
+		return err
+	}
+	key := gvr.String() + "/" + target.Namespace
+	kc.watchMutex.Lock()
+	if kc.watches == nil {
+		kc.watches = make(map[string]*replicaWatch)
+	}
+	rw, ok := kc.watches[key]
+	if !ok {
+		rw = &replicaWatch{handlers: make(map[string]func(replicas int32))}
+		kc.watches[key] = rw
+	}
+	kc.watchMutex.Unlock()
+	// HPA调整的副本数不算人工修改。在informer回调中,不能等待Resolver同步
+	handler := func(replicas int32) {
+		if hpa, err := kc.resolver.cachedHorizontalPodAutoscaler(target); err == nil && hpa != nil {
+			return
+		}
+		onChange(replicas)
+	}
+	rw.mutex.Lock()
+	rw.handlers[target.String()] = handler
+	rw.mutex.Unlock()
+	if ok {
+		return nil
+	}
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(kc.dynamic, resolverResync, target.Namespace, nil)
+	factory.ForResource(gvr).Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: rw.dispatch,
+		UpdateFunc: func(oldObj, obj interface{}) {
+			oldU, ok1 := oldObj.(*unstructured.Unstructured)
+			newU, ok2 := obj.(*unstructured.Unstructured)
+			if !ok1 || !ok2 {
+				return
+			}
+			oldReplicas, _, _ := unstructured.NestedInt64(oldU.Object, "spec", "replicas")
+			newReplicas, _, _ := unstructured.NestedInt64(newU.Object, "spec", "replicas")
+			if oldReplicas != newReplicas {
+				rw.dispatch(obj)
+			}
+		},
+	})
+	factory.Start(kc.resolver.stop)
+	return nil
+}
+
+// UnwatchReplicas 不再回调,informer继续保留给同一namespace的其他工作负载使用
+func (kc *k8SClient) UnwatchReplicas(target *Target) {
+	gvr, err := kc.resource(target)
+	if err != nil {
+		return
+	}
+	kc.watchMutex.Lock()
+	rw, ok := kc.watches[gvr.String()+"/"+target.Namespace]
+	kc.watchMutex.Unlock()
+	if !ok {
+		return
+	}
+	rw.mutex.Lock()
+	delete(rw.handlers, target.String())
+	rw.mutex.Unlock()
+}
+
+// SetManualPolicy 发现人工修改副本数时的处理方式。多副本部署时只有active返回true的副本处理
+func (sm *ScalerManage) SetManualPolicy(policy string, grace time.Duration, active func() bool) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.manualPolicy, sm.manualGrace, sm.manualActive = policy, grace, active
+}
+
+// Watch 开始监听服务的伸缩目标,用当前副本数初始化状态
+func (sm *ScalerManage) Watch(serviceName string) {
+	if _, err := sm.resolve(serviceName); err != nil {
+		log.Printf("watch %s replicas error %v", serviceName, err)
+	}
+}
+
+// watch 对还没有监听的目标开始监听
+func (sm *ScalerManage) watch(serviceName string, targets []*Target) {
+	watcher, ok := sm.backend(serviceName).(ReplicaWatcher)
+	if !ok {
+		return
+	}
+	for _, target := range targets {
+		key := target.String()
+		sm.mutex.Lock()
+		watched := sm.watched[key]
+		sm.watched[key] = true
+		sm.mutex.Unlock()
+		if watched {
+			continue
+		}
+		target := target
+		err := watcher.WatchReplicas(target, func(replicas int32) {
+			sm.observeReplicas(serviceName, target, replicas)
+		})
+		if err != nil {
+			log.Printf("watch %s(%s) replicas error %v", serviceName, target, err)
+			sm.mutex.Lock()
+			delete(sm.watched, key)
+			sm.mutex.Unlock()
+		}
+	}
+}
+
+// observeReplicas 副本数与最近一次写入的不同时,说明是其他人修改的
+func (sm *ScalerManage) observeReplicas(serviceName string, target *Target, replicas int32) {
+	key := target.String()
+	sm.mutex.Lock()
+	expected, ok := sm.expected[key]
+	sm.expected[key] = replicas
+	policy, active, notify := sm.manualPolicy, sm.manualActive, sm.notify
+	dryRun := sm.dryRuns[serviceName]
+	sm.mutex.Unlock()
+	if !ok || expected == replicas || policy == ManualIgnore || dryRun {
+		return
+	}
+	// follower没有执行伸缩,leader的修改不算人工修改
+	if active != nil && !active() {
+		return
+	}
+	msg := fmt.Sprintf("%s(%s) replicas changed outside simple-hpa from %d to %d", serviceName, target, expected, replicas)
+	sm.mutex.Lock()
+	switch policy {
+	case ManualFloor:
+		floor := sm.currentTotal(serviceName)
+		sm.override(serviceName).Floor = &floor
+		msg = fmt.Sprintf("%s, use %d as the new minimum until resumed", msg, floor)
+	default:
+		until := time.Now().Add(sm.manualGrace)
+		sm.override(serviceName).HoldUntil = &until
+		msg = fmt.Sprintf("%s, pause auto scaling until %s", msg, until.Format(time.RFC3339))
+	}
+	sm.mutex.Unlock()
+	log.Println(msg)
+	if notify != nil {
+		notify(msg)
+	}
+}
+
+// currentTotal 服务所有伸缩目标最近观察到的副本数之和。调用方需持有锁
+func (sm *ScalerManage) currentTotal(serviceName string) int32 {
+	targets := sm.targets[serviceName]
+	if len(targets) == 0 {
+		targets = sm.resolved[serviceName]
+	}
+	if len(targets) == 0 {
+		targets = sm.defaultTargets(serviceName)
+	}
+	var total int32
+	for _, target := range targets {
+		total += sm.expected[target.String()]
+	}
+	return total
+}
+
+// defaultTargets 没有配置和解析到目标时使用与服务同名的Deployment
+func (sm *ScalerManage) defaultTargets(serviceName string) []*Target {
+	namespaces := strings.Split(serviceName, ".")
+	if len(namespaces) != 2 {
+		return nil
+	}
+	return []*Target{NewTarget(DefaultAPIVersion, DefaultKind, namespaces[1], namespaces[0])}
+}
+
+// unwatch 清理服务所有目标的监听和副本数记录,返回正在监听的目标。调用方需持有锁
+func (sm *ScalerManage) unwatch(serviceName string) []*Target {
+	var watched []*Target
+	for _, targets := range [][]*Target{sm.targets[serviceName], sm.resolved[serviceName], sm.defaultTargets(serviceName)} {
+		for _, target := range targets {
+			key := target.String()
+			if sm.watched[key] {
+				watched = append(watched, target)
+			}
+			delete(sm.watched, key)
+			delete(sm.expected, key)
+		}
+	}
+	return watched
+}
+
+// Floor 人工修改后作为下限的副本数,没有时为0
+func (sm *ScalerManage) Floor(serviceName string) int32 {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	if o, ok := sm.overrides[serviceName]; ok && o.Floor != nil {
+		return *o.Floor
+	}
+	return 0
+}
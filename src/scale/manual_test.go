@@ -0,0 +1,113 @@
+package scale
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// watchScaler 记录回调,由测试模拟副本数变化
+type watchScaler struct {
+	stubScaler
+	onChange map[string]func(replicas int32)
+}
+
+func (s *watchScaler) WatchReplicas(target *Target, onChange func(replicas int32)) error {
+	s.onChange[target.Name] = onChange
+	return nil
+}
+
+func (s *watchScaler) UnwatchReplicas(target *Target) {
+	delete(s.onChange, target.Name)
+}
+
+func newWatchScaler(replicas map[string]int32) *watchScaler {
+	return &watchScaler{stubScaler: stubScaler{replicas: replicas}, onChange: make(map[string]func(replicas int32))}
+}
+
+func TestScalerManage_ManualHold(t *testing.T) {
+	client := newWatchScaler(map[string]int32{"web": 2})
+	sm := NewScaler(3, 60, client)
+	var msgs []string
+	sm.SetManualPolicy(ManualHold, time.Minute, nil)
+	sm.SetNotify(func(msg string) { msgs = append(msgs, msg) })
+	sm.Watch("web.demo")
+	// 启动时的副本数只用于初始化
+	client.onChange["web"](2)
+	newCount := int32(4)
+	if _, err := sm.ChangeServicePod("web.demo", &newCount, nil); err != nil {
+		t.Fatal(err)
+	}
+	client.onChange["web"](4)
+	if len(msgs) != 0 {
+		t.Fatalf("own change reported as manual: %v", msgs)
+	}
+	sm.histories = make(map[string]time.Time)
+	client.onChange["web"](6)
+	if len(msgs) != 1 || !strings.Contains(msgs[0], "from 4 to 6") {
+		t.Fatalf("want one manual change from 4 to 6, got %v", msgs)
+	}
+	o := sm.Overrides()["web.demo"]
+	if o.Paused || o.HoldUntil == nil {
+		t.Fatalf("want held with grace period, got %+v", o)
+	}
+	// 管理接口的resume只取消管理接口的暂停
+	sm.Resume("web.demo")
+	sm.histories["web.demo"] = time.Now().Add(-time.Hour)
+	if sm.NeedChange("web.demo") {
+		t.Fatal("should not scale in grace period")
+	}
+	sm.RemoveService("web.demo")
+	if len(sm.watched) != 0 || len(sm.expected) != 0 || len(client.onChange) != 0 {
+		t.Fatalf("want watch state cleared, got %v %v %d", sm.watched, sm.expected, len(client.onChange))
+	}
+}
+
+func TestReplicaWatch_Dispatch(t *testing.T) {
+	var got []string
+	rw := &replicaWatch{handlers: map[string]func(replicas int32){
+		NewTarget("", "", "demo", "web").String(): func(replicas int32) { got = append(got, "demo") },
+		NewTarget("", "", "shop", "web").String(): func(replicas int32) { got = append(got, "shop") },
+	}}
+	u := &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(3)}}}
+	u.SetAPIVersion(DefaultAPIVersion)
+	u.SetKind(DefaultKind)
+	u.SetNamespace("shop")
+	u.SetName("web")
+	rw.dispatch(u)
+	if len(got) != 1 || got[0] != "shop" {
+		t.Fatalf("want only shop handler, got %v", got)
+	}
+}
+
+func TestScalerManage_ManualFloor(t *testing.T) {
+	client := newWatchScaler(map[string]int32{"web-stable": 3, "web-canary": 1})
+	sm := NewScaler(3, 60, client)
+	sm.SetTargets("web.demo", []*Target{NewTarget("", "", "demo", "web-stable"), NewTarget("", "", "demo", "web-canary")})
+	sm.SetManualPolicy(ManualFloor, time.Minute, nil)
+	sm.Watch("web.demo")
+	client.onChange["web-stable"](3)
+	client.onChange["web-canary"](1)
+	client.onChange["web-stable"](5)
+	if floor := sm.Floor("web.demo"); floor != 6 {
+		t.Fatalf("want floor 6, got %d", floor)
+	}
+	sm.Resume("web.demo")
+	if floor := sm.Floor("web.demo"); floor != 0 {
+		t.Fatalf("want floor cleared, got %d", floor)
+	}
+}
+
+func TestScalerManage_ManualFollower(t *testing.T) {
+	client := newWatchScaler(map[string]int32{"web": 2})
+	sm := NewScaler(3, 60, client)
+	sm.SetManualPolicy(ManualHold, time.Minute, func() bool { return false })
+	sm.Watch("web.demo")
+	client.onChange["web"](2)
+	client.onChange["web"](3)
+	if _, ok := sm.Overrides()["web.demo"]; ok {
+		t.Fatal("follower should not hold the service")
+	}
+}
@@ -0,0 +1,12 @@
+package scale
+
+import "expvar"
+
+// 通过expvar导出,访问 http://<listen>/debug/vars 查看,key为svc.namespace
+var (
+	scaleSucceeded = expvar.NewMap("scale_succeeded_total")
+	scaleFailed    = expvar.NewMap("scale_failed_total")
+	// 试运行模式下本应执行的伸缩,以及模拟的各工作负载副本数
+	dryRunChanges  = expvar.NewMap("scale_dry_run_total")
+	dryRunReplicas = expvar.NewMap("dry_run_replicas")
+)
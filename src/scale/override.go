@@ -0,0 +1,261 @@
+package scale
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AllServices 暂停和冻结窗口可以作用于所有服务
+const AllServices = "*"
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// FreezeWindow 每周重复的冻结时间段,期间不伸缩
+type FreezeWindow struct {
+	Days  []time.Weekday `json:"days,omitempty"` // 为空时每天
+	Start time.Duration  `json:"start"`          // 距0点的时间
+	End   time.Duration  `json:"end"`            // 小于Start时跨过0点
+}
+
+// ParseFreezeWindow days形如"mon,tue",start、end形如"22:00"
+func ParseFreezeWindow(days, start, end string) (*FreezeWindow, error) {
+	fw := new(FreezeWindow)
+	for _, day := range strings.Split(days, ",") {
+		day = strings.ToLower(strings.TrimSpace(day))
+		if day == "" {
+			continue
+		}
+		weekday, ok := weekdays[day]
+		if !ok {
+			return nil, fmt.Errorf("invalid day %s, use mon,tue,...", day)
+		}
+		fw.Days = append(fw.Days, weekday)
+	}
+	var err error
+	if fw.Start, err = parseClock(start); err != nil {
+		return nil, err
+	}
+	if fw.End, err = parseClock(end); err != nil {
+		return nil, err
+	}
+	if fw.Start == fw.End {
+		return nil, fmt.Errorf("freeze window start equals end")
+	}
+	return fw, nil
+}
+
+func parseClock(clock string) (time.Duration, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %s, use HH:MM", clock)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (fw *FreezeWindow) onDay(day time.Weekday) bool {
+	if len(fw.Days) == 0 {
+		return true
+	}
+	for _, d := range fw.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+func (fw *FreezeWindow) contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	clock := t.Sub(midnight)
+	if fw.Start < fw.End {
+		return fw.onDay(t.Weekday()) && clock >= fw.Start && clock < fw.End
+	}
+	// 跨过0点,前半段属于开始的那一天
+	if clock >= fw.Start {
+		return fw.onDay(t.Weekday())
+	}
+	return clock < fw.End && fw.onDay(midnight.AddDate(0, 0, -1).Weekday())
+}
+
+func (fw *FreezeWindow) String() string {
+	days := make([]string, len(fw.Days))
+	for i, day := range fw.Days {
+		days[i] = day.String()[:3]
+	}
+	if len(days) == 0 {
+		days = []string{"daily"}
+	}
+	return fmt.Sprintf("%s %02d:%02d-%02d:%02d", strings.Join(days, ","),
+		int(fw.Start.Hours()), int(fw.Start.Minutes())%60, int(fw.End.Hours()), int(fw.End.Minutes())%60)
+}
+
+// Override 通过管理接口设置的人工干预,Until为空时一直有效
+type Override struct {
+	Paused          bool            `json:"paused,omitempty"`
+	PauseUntil      *time.Time      `json:"pauseUntil,omitempty"`
+	Pin             *int32          `json:"pin,omitempty"`
+	PinUntil        *time.Time      `json:"pinUntil,omitempty"`
+	MaxQps          float32         `json:"maxQps,omitempty"`
+	SafeQps         float32         `json:"safeQps,omitempty"`
+	ThresholdsUntil *time.Time      `json:"thresholdsUntil,omitempty"`
+	FreezeWindows   []*FreezeWindow `json:"freezeWindows,omitempty"`
+	Floor           *int32          `json:"floor,omitempty"`     // 人工修改后的副本数下限
+	HoldUntil       *time.Time      `json:"holdUntil,omitempty"` // 人工修改后的宽限期,Resume不清除
+}
+
+func expired(until *time.Time, now time.Time) bool {
+	return until != nil && !now.Before(*until)
+}
+
+// expire 清理过期的项,都过期时返回true
+func (o *Override) expire(now time.Time) bool {
+	if o.Paused && expired(o.PauseUntil, now) {
+		o.Paused, o.PauseUntil = false, nil
+	}
+	if o.Pin != nil && expired(o.PinUntil, now) {
+		o.Pin, o.PinUntil = nil, nil
+	}
+	if o.MaxQps > 0 && expired(o.ThresholdsUntil, now) {
+		o.MaxQps, o.SafeQps, o.ThresholdsUntil = 0, 0, nil
+	}
+	if expired(o.HoldUntil, now) {
+		o.HoldUntil = nil
+	}
+	return !o.Paused && o.Pin == nil && o.MaxQps <= 0 && len(o.FreezeWindows) == 0 && o.Floor == nil && o.HoldUntil == nil
+}
+
+// held 返回不能自动伸缩的原因,可以伸缩时为空
+func (o *Override) held(now time.Time) string {
+	if o.Paused {
+		return "paused"
+	}
+	if o.Pin != nil {
+		return fmt.Sprintf("pinned to %d", *o.Pin)
+	}
+	if o.HoldUntil != nil {
+		return "held after manual change until " + o.HoldUntil.Format(time.RFC3339)
+	}
+	for _, fw := range o.FreezeWindows {
+		if fw.contains(now) {
+			return "in freeze window " + fw.String()
+		}
+	}
+	return ""
+}
+
+// override 取得服务的Override,没有时创建。调用方需持有锁
+func (sm *ScalerManage) override(serviceName string) *Override {
+	o, ok := sm.overrides[serviceName]
+	if !ok {
+		o = new(Override)
+		sm.overrides[serviceName] = o
+	}
+	return o
+}
+
+func (sm *ScalerManage) Pause(serviceName string, until *time.Time) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	o := sm.override(serviceName)
+	o.Paused, o.PauseUntil = true, until
+}
+
+// Resume 取消暂停、固定副本数和人工设置的下限,恢复自动伸缩
+func (sm *ScalerManage) Resume(serviceName string) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	o := sm.override(serviceName)
+	o.Paused, o.PauseUntil = false, nil
+	o.Pin, o.PinUntil = nil, nil
+	o.Floor = nil
+	if o.expire(time.Now()) {
+		delete(sm.overrides, serviceName)
+	}
+}
+
+// Pin 立即把副本数改为replicas,until之前不再自动伸缩
+func (sm *ScalerManage) Pin(serviceName string, replicas int32, until *time.Time) (*Change, error) {
+	sm.mutex.Lock()
+	o := sm.override(serviceName)
+	o.Pin, o.PinUntil = &replicas, until
+	sm.mutex.Unlock()
+	return sm.ChangeServicePod(serviceName, &replicas, &Reason{Message: "pinned by admin"})
+}
+
+// SetThresholds 临时替换配置中的maxQps和safeQps
+func (sm *ScalerManage) SetThresholds(serviceName string, maxQps, safeQps float32, until *time.Time) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	o := sm.override(serviceName)
+	o.MaxQps, o.SafeQps, o.ThresholdsUntil = maxQps, safeQps, until
+}
+
+// Thresholds 返回生效的maxQps和safeQps
+func (sm *ScalerManage) Thresholds(serviceName string, maxQps, safeQps float32) (float32, float32) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	o, ok := sm.overrides[serviceName]
+	if !ok {
+		return maxQps, safeQps
+	}
+	o.expire(time.Now())
+	if o.MaxQps <= 0 {
+		return maxQps, safeQps
+	}
+	return o.MaxQps, o.SafeQps
+}
+
+func (sm *ScalerManage) AddFreezeWindow(serviceName string, fw *FreezeWindow) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	o := sm.override(serviceName)
+	o.FreezeWindows = append(o.FreezeWindows, fw)
+}
+
+func (sm *ScalerManage) ClearFreezeWindows(serviceName string) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	o := sm.override(serviceName)
+	o.FreezeWindows = nil
+	if o.expire(time.Now()) {
+		delete(sm.overrides, serviceName)
+	}
+}
+
+// Overrides 当前所有生效的人工干预
+func (sm *ScalerManage) Overrides() map[string]Override {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	now := time.Now()
+	result := make(map[string]Override, len(sm.overrides))
+	for serviceName, o := range sm.overrides {
+		if o.expire(now) {
+			delete(sm.overrides, serviceName)
+			continue
+		}
+		result[serviceName] = *o
+	}
+	return result
+}
+
+// held 服务本身或所有服务被暂停、固定或处于冻结窗口时返回原因。调用方需持有锁
+func (sm *ScalerManage) held(serviceName string, now time.Time) string {
+	for _, key := range []string{serviceName, AllServices} {
+		o, ok := sm.overrides[key]
+		if !ok {
+			continue
+		}
+		if o.expire(now) {
+			delete(sm.overrides, key)
+			continue
+		}
+		if reason := o.held(now); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
@@ -0,0 +1,91 @@
+package scale
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFreezeWindow_contains(t *testing.T) {
+	// 2021-09-06是周一
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2021, 9, day, hour, minute, 0, 0, time.Local)
+	}
+	cases := []struct {
+		days, start, end string
+		t                time.Time
+		want             bool
+	}{
+		{"", "09:00", "18:00", at(6, 12, 0), true},
+		{"", "09:00", "18:00", at(6, 18, 0), false},
+		{"sat,sun", "09:00", "18:00", at(6, 12, 0), false},
+		{"mon", "22:00", "02:00", at(6, 23, 0), true},
+		// 周一开始的窗口延续到周二凌晨
+		{"mon", "22:00", "02:00", at(7, 1, 0), true},
+		{"mon", "22:00", "02:00", at(6, 1, 0), false},
+	}
+	for _, c := range cases {
+		fw, err := ParseFreezeWindow(c.days, c.start, c.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fw.contains(c.t); got != c.want {
+			t.Errorf("%s contains %s = %t, want %t", fw, c.t, got, c.want)
+		}
+	}
+	if _, err := ParseFreezeWindow("someday", "09:00", "18:00"); err == nil {
+		t.Error("want invalid day error")
+	}
+	if _, err := ParseFreezeWindow("", "9", "18:00"); err == nil {
+		t.Error("want invalid time error")
+	}
+}
+
+func TestScalerManage_Override(t *testing.T) {
+	client := &stubScaler{replicas: map[string]int32{"web": 2}}
+	sm := NewScaler(1, 0, client)
+	sm.Update("web.demo", false, false)
+	sm.NeedChange("web.demo")
+	if !sm.NeedChange("web.demo") {
+		t.Fatal("want need change")
+	}
+
+	sm.Pause(AllServices, nil)
+	if sm.NeedChange("web.demo") {
+		t.Error("paused for all services")
+	}
+	sm.Resume(AllServices)
+	if !sm.NeedChange("web.demo") {
+		t.Error("want resumed")
+	}
+
+	change, err := sm.Pin("web.demo", 5, nil)
+	if err != nil || change == nil || client.replicas["web"] != 5 {
+		t.Fatalf("want pinned to 5, got %v %v", change, err)
+	}
+	if sm.NeedChange("web.demo") {
+		t.Error("pinned")
+	}
+	sm.Resume("web.demo")
+
+	past := time.Now().Add(-time.Second)
+	sm.SetThresholds("web.demo", 50, 30, &past)
+	if maxQps, safeQps := sm.Thresholds("web.demo", 10, 5); maxQps != 10 || safeQps != 5 {
+		t.Errorf("expired thresholds should not be used, got %.0f %.0f", maxQps, safeQps)
+	}
+	sm.SetThresholds("web.demo", 50, 30, nil)
+	if maxQps, safeQps := sm.Thresholds("web.demo", 10, 5); maxQps != 50 || safeQps != 30 {
+		t.Errorf("want thresholds 50/30, got %.0f %.0f", maxQps, safeQps)
+	}
+
+	now := time.Now()
+	sm.AddFreezeWindow("web.demo", &FreezeWindow{Start: 0, End: time.Hour*24 - time.Minute})
+	if now.Hour() == 23 && now.Minute() == 59 {
+		t.Skip("outside the freeze window")
+	}
+	if sm.NeedChange("web.demo") {
+		t.Error("in freeze window")
+	}
+	if len(sm.Overrides()) != 1 {
+		t.Errorf("want 1 override, got %v", sm.Overrides())
+	}
+}
@@ -0,0 +1,216 @@
+package scale
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// 缺少权限的服务,值为缺少的权限
+var degradedServices = expvar.NewMap("degraded_services")
+
+// Permission 伸缩一个工作负载需要的权限
+type Permission struct {
+	Namespace   string
+	Group       string
+	Resource    string
+	Subresource string
+	Verb        string
+}
+
+func (p Permission) resource() string {
+	if p.Subresource == "" {
+		return p.Resource
+	}
+	return p.Resource + "/" + p.Subresource
+}
+
+func (p Permission) String() string {
+	resource := p.Resource
+	if p.Group != "" {
+		resource += "." + p.Group
+	}
+	if p.Subresource != "" {
+		resource += "/" + p.Subresource
+	}
+	return fmt.Sprintf("%s %s in %s", p.Verb, resource, p.Namespace)
+}
+
+// AccessReviewer 检查当前身份是否有伸缩目标需要的权限,返回缺少的权限
+type AccessReviewer interface {
+	ReviewAccess(target *Target) ([]Permission, error)
+	ReviewNamespaceAccess(namespace string) ([]Permission, error)
+}
+
+// requiredPermissions /scale子资源用于读取、修改副本数,工作负载本身用于发布检查和人工修改检测
+func requiredPermissions(namespace, group, resource string) []Permission {
+	permissions := make([]Permission, 0, 5)
+	for _, verb := range []string{"get", "patch"} {
+		permissions = append(permissions, Permission{namespace, group, resource, "scale", verb})
+	}
+	for _, verb := range []string{"get", "list", "watch"} {
+		permissions = append(permissions, Permission{namespace, group, resource, "", verb})
+	}
+	return permissions
+}
+
+// namespacePermissions Resolver的informer需要list、watch,HPA管理的工作负载需要修改HPA的minReplicas
+func namespacePermissions(namespace string) []Permission {
+	permissions := make([]Permission, 0, 9)
+	for _, resource := range [][2]string{{"", "services"}, {"", "pods"}, {"apps", "replicasets"}, {"autoscaling", "horizontalpodautoscalers"}} {
+		for _, verb := range []string{"list", "watch"} {
+			permissions = append(permissions, Permission{namespace, resource[0], resource[1], "", verb})
+		}
+	}
+	return append(permissions, Permission{namespace, "autoscaling", "horizontalpodautoscalers", "", "patch"})
+}
+
+func (kc *k8SClient) ReviewAccess(target *Target) ([]Permission, error) {
+	gvr, err := kc.resource(target)
+	if err != nil {
+		return nil, err
+	}
+	return kc.reviewAccess(requiredPermissions(target.Namespace, gvr.Group, gvr.Resource))
+}
+
+func (kc *k8SClient) ReviewNamespaceAccess(namespace string) ([]Permission, error) {
+	return kc.reviewAccess(namespacePermissions(namespace))
+}
+
+func (kc *k8SClient) reviewAccess(permissions []Permission) ([]Permission, error) {
+	missing := make([]Permission, 0)
+	for _, p := range permissions {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   p.Namespace,
+					Verb:        p.Verb,
+					Group:       p.Group,
+					Resource:    p.Resource,
+					Subresource: p.Subresource,
+				},
+			},
+		}
+		result, err := kc.clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(context.TODO(), review, metav1.CreateOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if !result.Status.Allowed {
+			missing = append(missing, p)
+		}
+	}
+	return missing, nil
+}
+
+// Preflight 检查服务所有伸缩目标的权限,缺少权限时标记为degraded,返回缺少的权限。
+// 先检查namespace的权限,缺少时不通过Resolver解析,只检查配置的targetRef或同名Deployment。
+// 不支持检查的Scaler返回nil
+func (sm *ScalerManage) Preflight(serviceName string) ([]Permission, error) {
+	reviewer, ok := sm.backend(serviceName).(AccessReviewer)
+	if !ok {
+		return nil, nil
+	}
+	names := strings.Split(serviceName, ".")
+	if len(names) != 2 {
+		return nil, fmt.Errorf("%s no valid serviceName, use format like svc.namespace", serviceName)
+	}
+	missing, err := reviewer.ReviewNamespaceAccess(names[1])
+	if err != nil {
+		return nil, fmt.Errorf("review %s access error %w", serviceName, err)
+	}
+	var targets []*Target
+	if len(missing) == 0 {
+		if targets, err = sm.resolve(serviceName); err != nil {
+			return nil, err
+		}
+	} else {
+		sm.mutex.Lock()
+		targets = sm.targets[serviceName]
+		sm.mutex.Unlock()
+		if len(targets) == 0 {
+			targets = []*Target{NewTarget(DefaultAPIVersion, DefaultKind, names[1], names[0])}
+		}
+	}
+	for _, target := range targets {
+		permissions, err := reviewer.ReviewAccess(target)
+		if err != nil {
+			return nil, fmt.Errorf("review %s(%s) access error %w", serviceName, target, err)
+		}
+		missing = append(missing, permissions...)
+	}
+	if len(missing) == 0 {
+		degradedServices.Delete(serviceName)
+		return nil, nil
+	}
+	descriptions := make([]string, len(missing))
+	for i, p := range missing {
+		descriptions[i] = p.String()
+	}
+	v := new(expvar.String)
+	v.Set(strings.Join(descriptions, "; "))
+	degradedServices.Set(serviceName, v)
+	log.Printf("WARN %s degraded, missing permissions: %s", serviceName, v.Value())
+	return missing, nil
+}
+
+// ClusterRoleRules 缺少的权限对应的ClusterRole rules,可以直接加到deploy.yaml中
+func ClusterRoleRules(missing []Permission) string {
+	verbs := make(map[[2]string]map[string]bool)
+	for _, p := range missing {
+		key := [2]string{p.Group, p.resource()}
+		if verbs[key] == nil {
+			verbs[key] = make(map[string]bool)
+		}
+		verbs[key][p.Verb] = true
+	}
+	// 同一group下verbs相同的resources合并为一条
+	type rule struct {
+		group     string
+		verbs     []string
+		resources []string
+	}
+	rules := make(map[string]*rule)
+	keys := make([]string, 0)
+	for key, set := range verbs {
+		sorted := sortedKeys(set)
+		id := key[0] + "|" + strings.Join(sorted, ",")
+		r, ok := rules[id]
+		if !ok {
+			r = &rule{group: key[0], verbs: sorted}
+			rules[id] = r
+			keys = append(keys, id)
+		}
+		r.resources = append(r.resources, key[1])
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString("rules:\n")
+	for _, id := range keys {
+		r := rules[id]
+		sort.Strings(r.resources)
+		fmt.Fprintf(&b, "  - apiGroups:\n      - '%s'\n    resources:\n", r.group)
+		for _, resource := range r.resources {
+			fmt.Fprintf(&b, "      - '%s'\n", resource)
+		}
+		b.WriteString("    verbs:\n")
+		for _, verb := range r.verbs {
+			fmt.Fprintf(&b, "      - '%s'\n", verb)
+		}
+	}
+	return b.String()
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,114 @@
+package scale
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestScalerManage_Preflight(t *testing.T) {
+	kc, _ := newFakeK8SClient(map[string]int32{})
+	allowed := map[string]bool{"get deployments.apps/scale in demo": true, "get deployments.apps in demo": true}
+	for _, p := range namespacePermissions("demo") {
+		allowed[p.String()] = true
+	}
+	reviewWith(kc, allowed)
+	sm := NewScaler(3, 60, kc)
+	sm.SetTargets("web.demo", []*Target{NewTarget("", "", "demo", "web")})
+	missing, err := sm.Preflight("web.demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 3 || missing[0].String() != "patch deployments.apps/scale in demo" {
+		t.Fatalf("want patch scale, list and watch missing, got %v", missing)
+	}
+	if degradedServices.Get("web.demo") == nil {
+		t.Error("want web.demo degraded")
+	}
+	rules := ClusterRoleRules(missing)
+	want := `rules:
+  - apiGroups:
+      - 'apps'
+    resources:
+      - 'deployments'
+    verbs:
+      - 'list'
+      - 'watch'
+  - apiGroups:
+      - 'apps'
+    resources:
+      - 'deployments/scale'
+    verbs:
+      - 'patch'
+`
+	if rules != want {
+		t.Errorf("want rules\n%s\ngot\n%s", want, rules)
+	}
+
+	for _, p := range missing {
+		allowed[p.String()] = true
+	}
+	if missing, err = sm.Preflight("web.demo"); err != nil || len(missing) != 0 {
+		t.Fatalf("want no missing permissions, got %v %v", missing, err)
+	}
+	if degradedServices.Get("web.demo") != nil {
+		t.Error("want web.demo no longer degraded")
+	}
+	if strings.Contains(ClusterRoleRules(nil), "apiGroups") {
+		t.Error("want no rules")
+	}
+}
+
+func reviewWith(kc *k8SClient, allowed map[string]bool) {
+	kc.clientset.(*fake.Clientset).PrependReactor("create", "selfsubjectaccessreviews",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+			attrs := review.Spec.ResourceAttributes
+			p := Permission{attrs.Namespace, attrs.Group, attrs.Resource, attrs.Subresource, attrs.Verb}
+			review.Status.Allowed = allowed[p.String()]
+			return true, review, nil
+		})
+}
+
+func TestScalerManage_PreflightResolverDenied(t *testing.T) {
+	kc, _ := newFakeK8SClient(map[string]int32{})
+	allowed := make(map[string]bool)
+	for _, p := range requiredPermissions("demo", "apps", "deployments") {
+		allowed[p.String()] = true
+	}
+	reviewWith(kc, allowed)
+	// 没有list权限时informer永远不会同步
+	kc.clientset.(*fake.Clientset).PrependReactor("list", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(action.GetResource().GroupResource(), "", fmt.Errorf("denied"))
+	})
+	sm := NewScaler(3, 60, kc)
+	done := make(chan []Permission)
+	go func() {
+		missing, err := sm.Preflight("web.demo")
+		if err != nil {
+			t.Error(err)
+		}
+		done <- missing
+	}()
+	select {
+	case missing := <-done:
+		rules := ClusterRoleRules(missing)
+		for _, want := range []string{"'services'", "'pods'", "'replicasets'", "'horizontalpodautoscalers'", "'patch'"} {
+			if !strings.Contains(rules, want) {
+				t.Errorf("want %s in rules\n%s", want, rules)
+			}
+		}
+		if len(missing) != len(namespacePermissions("demo")) {
+			t.Errorf("want only resolver permissions missing, got %v", missing)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("preflight blocked on the resolver")
+	}
+}
@@ -0,0 +1,135 @@
+package scale
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// 请求太少时错误率没有意义,不参与比较
+	minRegressionRequests = 20
+	// 缩容前基线的平滑系数
+	baselineWeight = 0.3
+)
+
+// RegressionPolicy 缩容后Window内5xx比例比缩容前高出ErrorRate,或平均延迟超过缩容前的LatencyRatio倍时,
+// 恢复缩容前的副本数,并在Suppress内不再缩容。ErrorRate、LatencyRatio为0时不检查该项
+type RegressionPolicy struct {
+	Window       time.Duration
+	ErrorRate    float64
+	LatencyRatio float64
+	Suppress     time.Duration
+}
+
+// Signal 一个采样周期的请求结果
+type Signal struct {
+	Requests  int
+	ErrorRate float64
+	Latency   float64 // 平均响应时间,单位秒
+}
+
+// regressionWatch 缩容后的观察期
+type regressionWatch struct {
+	baseline Signal
+	until    time.Time
+	change   *Change
+}
+
+func (sm *ScalerManage) SetRegressionPolicy(policy *RegressionPolicy) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.regression = policy
+}
+
+// watchRegression 缩容成功后开始观察,没有缩容前的基线时不观察
+func (sm *ScalerManage) watchRegression(serviceName string, change *Change) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	baseline, ok := sm.baselines[serviceName]
+	if sm.regression == nil || !ok {
+		return
+	}
+	sm.regressions[serviceName] = &regressionWatch{
+		baseline: *baseline,
+		until:    time.Now().Add(sm.regression.Window),
+		change:   change,
+	}
+}
+
+// CheckRegression 每个采样周期调用。观察期外更新基线,观察期内变差时立即恢复缩容前的副本数,
+// 不受冷却时间限制。没有恢复时返回nil
+func (sm *ScalerManage) CheckRegression(serviceName string, signal Signal) (*Change, error) {
+	sm.mutex.Lock()
+	policy := sm.regression
+	if policy == nil || signal.Requests < minRegressionRequests {
+		sm.mutex.Unlock()
+		return nil, nil
+	}
+	now := time.Now()
+	w, ok := sm.regressions[serviceName]
+	if ok && now.After(w.until) {
+		delete(sm.regressions, serviceName)
+		ok = false
+	}
+	if !ok {
+		sm.updateBaseline(serviceName, signal)
+		sm.mutex.Unlock()
+		return nil, nil
+	}
+	// 缩容后被暂停或固定副本数时不回滚,以人工设置为准
+	if held := sm.held(serviceName, now); held != "" {
+		delete(sm.regressions, serviceName)
+		sm.mutex.Unlock()
+		log.Printf("%s stop watching for regression: %s", serviceName, held)
+		return nil, nil
+	}
+	reason := regressed(policy, w.baseline, signal)
+	if reason == "" {
+		sm.mutex.Unlock()
+		return nil, nil
+	}
+	delete(sm.regressions, serviceName)
+	sm.suppressed[serviceName] = now.Add(policy.Suppress)
+	sm.mutex.Unlock()
+	log.Printf("%s regressed after scaling down from %d to %d: %s", serviceName, w.change.Old, w.change.New, reason)
+	cnt := w.change.Old
+	return sm.ChangeServicePod(serviceName, &cnt, &Reason{
+		Message: fmt.Sprintf("roll back scale-down, %s, no scale-down for %s", reason, policy.Suppress),
+	})
+}
+
+// updateBaseline 调用时需要持有sm.mutex
+func (sm *ScalerManage) updateBaseline(serviceName string, signal Signal) {
+	baseline, ok := sm.baselines[serviceName]
+	if !ok {
+		sm.baselines[serviceName] = &signal
+		return
+	}
+	baseline.Requests = signal.Requests
+	baseline.ErrorRate += (signal.ErrorRate - baseline.ErrorRate) * baselineWeight
+	baseline.Latency += (signal.Latency - baseline.Latency) * baselineWeight
+}
+
+func regressed(policy *RegressionPolicy, baseline, signal Signal) string {
+	reasons := make([]string, 0, 2)
+	if policy.ErrorRate > 0 && signal.ErrorRate-baseline.ErrorRate > policy.ErrorRate {
+		reasons = append(reasons, fmt.Sprintf("error rate %.1f%% > baseline %.1f%%",
+			signal.ErrorRate*100, baseline.ErrorRate*100))
+	}
+	if policy.LatencyRatio > 0 && baseline.Latency > 0 && signal.Latency > baseline.Latency*policy.LatencyRatio {
+		reasons = append(reasons, fmt.Sprintf("latency %.3fs > baseline %.3fs", signal.Latency, baseline.Latency))
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// scaleDownSuppressed 回滚后的一段时间内不缩容,调用时需要持有sm.mutex
+func (sm *ScalerManage) scaleDownSuppressed(serviceName string, now time.Time) bool {
+	until, ok := sm.suppressed[serviceName]
+	if ok && now.After(until) {
+		delete(sm.suppressed, serviceName)
+		return false
+	}
+	return ok
+}
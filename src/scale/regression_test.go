@@ -0,0 +1,63 @@
+package scale
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScalerManage_CheckRegression(t *testing.T) {
+	client := &stubScaler{replicas: map[string]int32{"web": 4}}
+	sm := NewScaler(3, 60, client)
+	sm.SetRegressionPolicy(&RegressionPolicy{Window: time.Minute, ErrorRate: 0.05, LatencyRatio: 2, Suppress: time.Minute})
+	if change, err := sm.CheckRegression("web.demo", Signal{Requests: 100, ErrorRate: 0.01, Latency: 0.1}); change != nil || err != nil {
+		t.Fatalf("baseline should not roll back, got %v %v", change, err)
+	}
+	newCount := int32(2)
+	if _, err := sm.ChangeServicePod("web.demo", &newCount, nil); err != nil {
+		t.Fatal(err)
+	}
+	// 请求太少或没有超过阈值时不回滚
+	sm.CheckRegression("web.demo", Signal{Requests: 5, ErrorRate: 1})
+	if change, _ := sm.CheckRegression("web.demo", Signal{Requests: 100, ErrorRate: 0.03, Latency: 0.15}); change != nil {
+		t.Fatalf("want no rollback within thresholds, got %v", change)
+	}
+	change, err := sm.CheckRegression("web.demo", Signal{Requests: 100, ErrorRate: 0.01, Latency: 0.5})
+	if err != nil || change == nil || change.New != 4 || client.replicas["web"] != 4 {
+		t.Fatalf("want rollback to 4, got %v %v", change, err)
+	}
+	if !strings.Contains(change.Reason.String(), "latency") {
+		t.Errorf("want latency in reason, got %s", change.Reason)
+	}
+	if change, _ = sm.ChangeServicePod("web.demo", &newCount, nil); change != nil || client.replicas["web"] != 4 {
+		t.Fatalf("scale-down should be suppressed, got %v", change)
+	}
+	upCount := int32(5)
+	if change, _ = sm.ChangeServicePod("web.demo", &upCount, nil); change == nil || client.replicas["web"] != 5 {
+		t.Fatalf("scale-up should not be suppressed, got %v", change)
+	}
+	// 管理接口固定副本数不受限制
+	if change, err = sm.Pin("web.demo", 2, nil); err != nil || change == nil || client.replicas["web"] != 2 {
+		t.Fatalf("pin should bypass suppression, got %v %v", change, err)
+	}
+}
+
+func TestScalerManage_CheckRegressionHeld(t *testing.T) {
+	client := &stubScaler{replicas: map[string]int32{"web": 4}}
+	sm := NewScaler(3, 0, client)
+	sm.SetRegressionPolicy(&RegressionPolicy{Window: time.Minute, ErrorRate: 0.05, Suppress: time.Minute})
+	sm.CheckRegression("web.demo", Signal{Requests: 100, ErrorRate: 0.01})
+	newCount := int32(2)
+	if _, err := sm.ChangeServicePod("web.demo", &newCount, nil); err != nil {
+		t.Fatal(err)
+	}
+	// 缩容后人工暂停,变差时也不回滚
+	sm.Pause("web.demo", nil)
+	if change, err := sm.CheckRegression("web.demo", Signal{Requests: 100, ErrorRate: 0.5}); change != nil || err != nil {
+		t.Fatalf("paused service should not roll back, got %v %v", change, err)
+	}
+	sm.Resume("web.demo")
+	if change, _ := sm.CheckRegression("web.demo", Signal{Requests: 100, ErrorRate: 0.5}); change != nil || client.replicas["web"] != 2 {
+		t.Fatalf("watch should be dropped after a hold, got %v", change)
+	}
+}
@@ -0,0 +1,203 @@
+package scale
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	autoscalinglisters "k8s.io/client-go/listers/autoscaling/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	resolverResync = time.Minute * 10
+	// 等待informer同步的检查间隔和超时时间
+	resolverSyncPeriod  = time.Millisecond * 100
+	resolverSyncTimeout = time.Second * 30
+)
+
+// TargetResolver 根据Service找到实际承载流量的工作负载
+type TargetResolver interface {
+	ResolveTargets(namespace, service string) ([]*Target, error)
+}
+
+func NewResolver(clientset kubernetes.Interface) *Resolver {
+	return &Resolver{
+		clientset:   clientset,
+		stop:        make(chan struct{}),
+		listers:     make(map[string]*namespaceListers),
+		syncTimeout: resolverSyncTimeout,
+	}
+}
+
+// Resolver 通过Service的selector找到Pod,再顺着ownerReferences找到工作负载。
+// 每个用到的namespace启动一组informer,查询都走本地缓存
+type Resolver struct {
+	mutex       sync.Mutex
+	clientset   kubernetes.Interface
+	stop        chan struct{}
+	listers     map[string]*namespaceListers
+	syncTimeout time.Duration
+}
+
+type namespaceListers struct {
+	services    corelisters.ServiceNamespaceLister
+	pods        corelisters.PodNamespaceLister
+	replicaSets appslisters.ReplicaSetNamespaceLister
+	hpas        autoscalinglisters.HorizontalPodAutoscalerNamespaceLister
+	synced      []cache.InformerSynced
+	stop        chan struct{} // 同步失败时停止这组informer
+}
+
+func (r *Resolver) namespace(namespace string) (*namespaceListers, error) {
+	r.mutex.Lock()
+	nl, ok := r.listers[namespace]
+	if !ok {
+		nl = r.start(namespace)
+		r.listers[namespace] = nl
+	}
+	r.mutex.Unlock()
+	// 不持有锁等待,没有list权限或apiserver不可用时不影响其他namespace
+	err := wait.PollImmediate(resolverSyncPeriod, r.syncTimeout, func() (bool, error) {
+		for _, synced := range nl.synced {
+			if !synced() {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		r.mutex.Lock()
+		if r.listers[namespace] == nl {
+			delete(r.listers, namespace)
+			close(nl.stop)
+		}
+		r.mutex.Unlock()
+		return nil, fmt.Errorf("namespace %s informer cache sync failed: %w", namespace, err)
+	}
+	return nl, nil
+}
+
+// start 启动namespace的informer,调用时需要持有r.mutex
+func (r *Resolver) start(namespace string) *namespaceListers {
+	factory := informers.NewSharedInformerFactoryWithOptions(r.clientset, resolverResync, informers.WithNamespace(namespace))
+	services := factory.Core().V1().Services()
+	pods := factory.Core().V1().Pods()
+	replicaSets := factory.Apps().V1().ReplicaSets()
+	hpas := factory.Autoscaling().V1().HorizontalPodAutoscalers()
+	nl := &namespaceListers{
+		services:    services.Lister().Services(namespace),
+		pods:        pods.Lister().Pods(namespace),
+		replicaSets: replicaSets.Lister().ReplicaSets(namespace),
+		hpas:        hpas.Lister().HorizontalPodAutoscalers(namespace),
+		synced: []cache.InformerSynced{
+			services.Informer().HasSynced,
+			pods.Informer().HasSynced,
+			replicaSets.Informer().HasSynced,
+			hpas.Informer().HasSynced,
+		},
+		stop: make(chan struct{}),
+	}
+	factory.Start(nl.stop)
+	return nl
+}
+
+func (r *Resolver) ResolveTargets(namespace, service string) ([]*Target, error) {
+	nl, err := r.namespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	svc, err := nl.services.Get(service)
+	if err != nil {
+		return nil, err
+	}
+	if len(svc.Spec.Selector) == 0 {
+		return nil, fmt.Errorf("service %s.%s has no selector", service, namespace)
+	}
+	selector := labels.SelectorFromSet(svc.Spec.Selector)
+	pods, err := nl.pods.List(selector)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]*Target)
+	for _, pod := range pods {
+		owner := metav1.GetControllerOf(pod)
+		if owner == nil {
+			continue
+		}
+		target, err := r.owner(nl, namespace, owner)
+		if err != nil {
+			return nil, err
+		}
+		if target != nil {
+			found[target.String()] = target
+		}
+	}
+	if len(found) == 0 {
+		// 缩到0时没有Pod,通过ReplicaSet的Pod模板找到工作负载
+		if found, err = r.scaledToZero(nl, namespace, selector); err != nil {
+			return nil, err
+		}
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("service %s.%s has no pod or replicaset owned by a workload", service, namespace)
+	}
+	targets := make([]*Target, 0, len(found))
+	for _, target := range found {
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].String() < targets[j].String()
+	})
+	return targets, nil
+}
+
+// scaledToZero Pod模板匹配selector的ReplicaSet所属的工作负载,缩到0的Deployment、Argo Rollout仍然保留ReplicaSet
+func (r *Resolver) scaledToZero(nl *namespaceListers, namespace string, selector labels.Selector) (map[string]*Target, error) {
+	replicaSets, err := nl.replicaSets.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]*Target)
+	for _, rs := range replicaSets {
+		if !selector.Matches(labels.Set(rs.Spec.Template.Labels)) {
+			continue
+		}
+		ref := &metav1.OwnerReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: rs.Name}
+		target, err := r.owner(nl, namespace, ref)
+		if err != nil {
+			return nil, err
+		}
+		if target != nil {
+			found[target.String()] = target
+		}
+	}
+	return found, nil
+}
+
+// owner ReplicaSet再往上找一层,Deployment、Argo Rollout等都是通过ReplicaSet管理Pod的。
+// ReplicaSet已被删除的Pod正在退出,返回nil
+func (r *Resolver) owner(nl *namespaceListers, namespace string, owner *metav1.OwnerReference) (*Target, error) {
+	if owner.Kind == "ReplicaSet" && owner.APIVersion == "apps/v1" {
+		rs, err := nl.replicaSets.Get(owner.Name)
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rsOwner := metav1.GetControllerOf(rs); rsOwner != nil {
+			owner = rsOwner
+		}
+	}
+	return NewTarget(owner.APIVersion, owner.Kind, namespace, owner.Name), nil
+}
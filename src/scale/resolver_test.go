@@ -0,0 +1,125 @@
+package scale
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+func controllerRef(apiVersion, kind, name string) []metav1.OwnerReference {
+	isController := true
+	return []metav1.OwnerReference{{APIVersion: apiVersion, Kind: kind, Name: name, Controller: &isController}}
+}
+
+func newPod(name string, labels map[string]string, owners []metav1.OwnerReference) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name: name, Namespace: "demo", Labels: labels, OwnerReferences: owners,
+	}}
+}
+
+func TestResolver_ResolveTargets(t *testing.T) {
+	web := map[string]string{"app": "web"}
+	objects := []runtime.Object{
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "demo"},
+			Spec:       corev1.ServiceSpec{Selector: web},
+		},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+			Name: "web-v2-5d9c", Namespace: "demo", OwnerReferences: controllerRef("apps/v1", "Deployment", "web-v2"),
+		}},
+		newPod("web-v2-5d9c-a", web, controllerRef("apps/v1", "ReplicaSet", "web-v2-5d9c")),
+		newPod("web-v2-5d9c-b", web, controllerRef("apps/v1", "ReplicaSet", "web-v2-5d9c")),
+		newPod("web-db-0", web, controllerRef("apps/v1", "StatefulSet", "web-db")),
+		newPod("other", map[string]string{"app": "other"}, controllerRef("apps/v1", "StatefulSet", "other")),
+		// ReplicaSet已被删除
+		newPod("web-v1-0", web, controllerRef("apps/v1", "ReplicaSet", "web-v1")),
+	}
+	resolver := NewResolver(fake.NewSimpleClientset(objects...))
+	targets, err := resolver.ResolveTargets("demo", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("want 2 targets, got %v", targets)
+	}
+	if targets[0].Kind != "Deployment" || targets[0].Name != "web-v2" {
+		t.Errorf("want Deployment web-v2, got %s", targets[0])
+	}
+	if targets[1].Kind != "StatefulSet" || targets[1].Name != "web-db" {
+		t.Errorf("want StatefulSet web-db, got %s", targets[1])
+	}
+	if _, err = resolver.ResolveTargets("demo", "missing"); err == nil {
+		t.Error("want error for missing service")
+	}
+}
+
+func TestResolver_SyncTimeout(t *testing.T) {
+	web := map[string]string{"app": "web"}
+	clientset := fake.NewSimpleClientset(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "demo"},
+		Spec:       corev1.ServiceSpec{Selector: web},
+	}, newPod("web-0", web, controllerRef("apps/v1", "StatefulSet", "web")))
+	clientset.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "forbidden" {
+			return true, nil, errors.NewForbidden(corev1.Resource("pods"), "", fmt.Errorf("no list permission"))
+		}
+		return false, nil, nil
+	})
+	resolver := NewResolver(clientset)
+	resolver.syncTimeout = time.Millisecond * 500
+	done := make(chan error)
+	go func() {
+		_, err := resolver.ResolveTargets("forbidden", "web")
+		done <- err
+	}()
+	// 同步中的namespace不影响其他namespace
+	if _, err := resolver.ResolveTargets("demo", "web"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("want sync timeout")
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("sync did not time out")
+	}
+	if _, ok := resolver.listers["forbidden"]; ok {
+		t.Error("failed informers should not be cached")
+	}
+}
+
+func TestScalerManage_WakeScaledToZero(t *testing.T) {
+	web := map[string]string{"app": "web"}
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "web-v2-5d9c", Namespace: "demo", OwnerReferences: controllerRef("apps/v1", "Deployment", "web-v2"),
+		},
+		Spec: appsv1.ReplicaSetSpec{Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: web}}},
+	}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "demo"},
+		Spec:       corev1.ServiceSpec{Selector: web},
+	}
+	replicas := map[string]int32{"deployments/demo/web-v2": 0}
+	kc, _ := newFakeK8SClient(replicas, svc, rs)
+	kc.recorder = record.NewFakeRecorder(10)
+	// 重启后没有解析缓存,也没有Pod
+	sm := NewScaler(3, 60, kc)
+	change, err := sm.Wake("web.demo", 1)
+	if err != nil || change == nil {
+		t.Fatalf("want wake up, got %v %v", change, err)
+	}
+	if replicas["deployments/demo/web-v2"] != 1 {
+		t.Fatalf("want web-v2 scaled to 1, got %v", replicas)
+	}
+}
@@ -0,0 +1,150 @@
+package scale
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// RolloutChecker 工作负载正在发布或新Pod还不可用时,每Pod的QPS不准确,跳过伸缩判断
+type RolloutChecker interface {
+	RolloutInProgress(target *Target) (bool, string, error)
+}
+
+// WarmUpChecker 找出就绪还不到warmUp的Pod,addresses为ingress日志中的upstream
+type WarmUpChecker interface {
+	WarmingUp(namespace string, addresses []string, warmUp time.Duration) (map[string]bool, error)
+}
+
+func (kc *k8SClient) RolloutInProgress(target *Target) (bool, string, error) {
+	gvr, err := kc.resource(target)
+	if err != nil {
+		return false, "", err
+	}
+	obj, err := kc.dynamic.Resource(gvr).Namespace(target.Namespace).Get(context.TODO(), target.Name, metav1.GetOptions{})
+	if err != nil {
+		return false, "", err
+	}
+	inProgress, msg := rolloutInProgress(obj, gvr.Group == "apps")
+	return inProgress, msg, nil
+}
+
+// rolloutInProgress 按Deployment、StatefulSet的status字段判断,其他类型只检查存在的字段。
+// apps下的类型status中为0的字段会被省略
+func rolloutInProgress(obj *unstructured.Unstructured, apps bool) (bool, string) {
+	observed, found, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	if found && observed < obj.GetGeneration() {
+		return true, fmt.Sprintf("observedGeneration %d < generation %d", observed, obj.GetGeneration())
+	}
+	replicas, found, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	if !found || replicas == 0 {
+		return false, ""
+	}
+	for _, field := range []string{"updatedReplicas", "availableReplicas"} {
+		cnt, found, _ := unstructured.NestedInt64(obj.Object, "status", field)
+		if !found && field == "availableReplicas" {
+			// 旧版本StatefulSet没有availableReplicas
+			cnt, found, _ = unstructured.NestedInt64(obj.Object, "status", "readyReplicas")
+		}
+		if (found || apps) && cnt < replicas {
+			return true, fmt.Sprintf("%s %d < replicas %d", field, cnt, replicas)
+		}
+	}
+	return false, ""
+}
+
+func (kc *k8SClient) WarmingUp(namespace string, addresses []string, warmUp time.Duration) (map[string]bool, error) {
+	return kc.resolver.WarmingUp(namespace, addresses, warmUp)
+}
+
+func (r *Resolver) WarmingUp(namespace string, addresses []string, warmUp time.Duration) (map[string]bool, error) {
+	nl, err := r.namespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := nl.pods.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	readyTimes := make(map[string]time.Time, len(pods))
+	for _, pod := range pods {
+		if pod.Status.PodIP == "" {
+			continue
+		}
+		readyTimes[pod.Status.PodIP] = readyTime(pod)
+	}
+	warming := make(map[string]bool)
+	for _, address := range addresses {
+		host := address
+		if h, _, err := net.SplitHostPort(strings.TrimSpace(address)); err == nil {
+			host = h
+		}
+		if t, ok := readyTimes[host]; ok && time.Since(t) < warmUp {
+			warming[address] = true
+		}
+	}
+	return warming, nil
+}
+
+// readyTime Pod变为Ready的时间,没有Ready条件时用启动时间
+func readyTime(pod *corev1.Pod) time.Time {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+			return condition.LastTransitionTime.Time
+		}
+	}
+	if pod.Status.StartTime != nil {
+		return pod.Status.StartTime.Time
+	}
+	return pod.CreationTimestamp.Time
+}
+
+// rolloutInProgress 任一伸缩目标在发布中时返回true,查询失败时不影响伸缩
+func (sm *ScalerManage) rolloutInProgress(serviceName string) bool {
+	checker, ok := sm.backend(serviceName).(RolloutChecker)
+	if !ok {
+		return false
+	}
+	targets, err := sm.resolve(serviceName)
+	if err != nil {
+		return false
+	}
+	for _, target := range targets {
+		inProgress, msg, err := checker.RolloutInProgress(target)
+		if err != nil {
+			log.Printf("check %s(%s) rollout error %v", serviceName, target, err)
+			continue
+		}
+		if inProgress {
+			log.Printf("skip %s, %s rollout in progress: %s", serviceName, target, msg)
+			return true
+		}
+	}
+	return false
+}
+
+// WarmingUp 服务的upstream中还在预热的Pod
+func (sm *ScalerManage) WarmingUp(serviceName string, addresses []string, warmUp time.Duration) map[string]bool {
+	checker, ok := sm.backend(serviceName).(WarmUpChecker)
+	if !ok || warmUp <= 0 || len(addresses) == 0 {
+		return nil
+	}
+	namespaces := strings.Split(serviceName, ".")
+	if len(namespaces) != 2 {
+		return nil
+	}
+	warming, err := checker.WarmingUp(namespaces[1], addresses, warmUp)
+	if err != nil {
+		log.Printf("check %s warm-up pods error %v", serviceName, err)
+		return nil
+	}
+	return warming
+}
@@ -0,0 +1,65 @@
+package scale
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRolloutInProgress(t *testing.T) {
+	newObj := func(generation int64, spec, status map[string]interface{}) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec, "status": status}}
+		obj.SetGeneration(generation)
+		return obj
+	}
+	cases := []struct {
+		name string
+		obj  *unstructured.Unstructured
+		apps bool
+		want bool
+	}{
+		{"done", newObj(2, map[string]interface{}{"replicas": int64(3)},
+			map[string]interface{}{"observedGeneration": int64(2), "updatedReplicas": int64(3), "availableReplicas": int64(3)}), true, false},
+		{"not observed", newObj(3, map[string]interface{}{"replicas": int64(3)},
+			map[string]interface{}{"observedGeneration": int64(2), "updatedReplicas": int64(3), "availableReplicas": int64(3)}), true, true},
+		{"updating", newObj(2, map[string]interface{}{"replicas": int64(3)},
+			map[string]interface{}{"observedGeneration": int64(2), "updatedReplicas": int64(1), "availableReplicas": int64(3)}), true, true},
+		{"scaled up", newObj(2, map[string]interface{}{"replicas": int64(5)},
+			map[string]interface{}{"observedGeneration": int64(2), "updatedReplicas": int64(5), "availableReplicas": int64(3)}), true, true},
+		{"no available pod", newObj(2, map[string]interface{}{"replicas": int64(1)},
+			map[string]interface{}{"observedGeneration": int64(2), "updatedReplicas": int64(1)}), true, true},
+		{"custom kind", newObj(2, map[string]interface{}{"replicas": int64(3)},
+			map[string]interface{}{"observedGeneration": int64(2)}), false, false},
+	}
+	for _, c := range cases {
+		if got, msg := rolloutInProgress(c.obj, c.apps); got != c.want {
+			t.Errorf("%s: want %t, got %t %s", c.name, c.want, got, msg)
+		}
+	}
+}
+
+func TestResolver_WarmingUp(t *testing.T) {
+	newPod := func(name, ip string, ready time.Time) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "demo"},
+			Status: corev1.PodStatus{PodIP: ip, Conditions: []corev1.PodCondition{{
+				Type: corev1.PodReady, Status: corev1.ConditionTrue, LastTransitionTime: metav1.NewTime(ready),
+			}}},
+		}
+	}
+	resolver := NewResolver(fake.NewSimpleClientset(
+		newPod("old", "10.0.0.1", time.Now().Add(-time.Hour)),
+		newPod("new", "10.0.0.2", time.Now().Add(-time.Second*10)),
+	))
+	warming, err := resolver.WarmingUp("demo", []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warming) != 1 || !warming["10.0.0.2:80"] {
+		t.Errorf("want only 10.0.0.2:80 warming up, got %v", warming)
+	}
+}
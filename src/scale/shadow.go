@@ -0,0 +1,38 @@
+package scale
+
+import (
+	"expvar"
+	"sync"
+)
+
+func newShadowScaler(real Scaler) *shadowScaler {
+	return &shadowScaler{real: real, replicas: make(map[string]int32)}
+}
+
+// shadowScaler 试运行时代替真实的Scaler。第一次读取真实副本数,之后的修改只记录在内存里,
+// 连续的试运行决策都基于模拟出来的副本数
+type shadowScaler struct {
+	mutex    sync.Mutex
+	real     Scaler
+	replicas map[string]int32
+}
+
+func (ss *shadowScaler) GetServicePod(target *Target) (*int32, error) {
+	ss.mutex.Lock()
+	cnt, ok := ss.replicas[target.String()]
+	ss.mutex.Unlock()
+	if ok {
+		return &cnt, nil
+	}
+	return ss.real.GetServicePod(target)
+}
+
+func (ss *shadowScaler) ChangeServicePod(target *Target, newCount *int32) error {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	ss.replicas[target.String()] = *newCount
+	v := new(expvar.Int)
+	v.Set(int64(*newCount))
+	dryRunReplicas.Set(target.String(), v)
+	return nil
+}
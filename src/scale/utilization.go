@@ -0,0 +1,201 @@
+package scale
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// 利用率与目标相差不超过10%时不调整,同HPA
+const utilizationTolerance = 0.1
+
+// ResourceTargets CPU、内存的目标利用率,为requests的百分比,0为不使用
+type ResourceTargets struct {
+	CPU    int32
+	Memory int32
+}
+
+// Utilization 工作负载有指标的Pod的平均利用率,为requests的百分比,requests为0的资源为-1
+type Utilization struct {
+	CPU    float64
+	Memory float64
+	Pods   int
+}
+
+// UtilizationReader 从metrics.k8s.io读取工作负载Pod的CPU、内存利用率
+type UtilizationReader interface {
+	Utilization(target *Target) (*Utilization, error)
+}
+
+// Recommendation 按CPU、内存利用率计算的副本数
+type Recommendation struct {
+	Current int32
+	Desired int32
+	Reason  string
+}
+
+func (kc *k8SClient) Utilization(target *Target) (*Utilization, error) {
+	if kc.metrics == nil {
+		return nil, fmt.Errorf("metrics.k8s.io client not available")
+	}
+	gvr, err := kc.resource(target)
+	if err != nil {
+		return nil, err
+	}
+	s, err := kc.scales.Scales(target.Namespace).Get(context.TODO(), gvr.GroupResource(), target.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	selector, err := labels.Parse(s.Status.Selector)
+	if err != nil {
+		return nil, err
+	}
+	nl, err := kc.resolver.namespace(target.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := nl.pods.List(selector)
+	if err != nil {
+		return nil, err
+	}
+	metrics, err := kc.metrics.MetricsV1beta1().PodMetricses(target.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	usages := make(map[string]corev1.ResourceList, len(metrics.Items))
+	for _, item := range metrics.Items {
+		usage := corev1.ResourceList{}
+		for _, container := range item.Containers {
+			for name, q := range container.Usage {
+				sum := usage[name]
+				sum.Add(q)
+				usage[name] = sum
+			}
+		}
+		usages[item.Name] = usage
+	}
+	// 只统计运行中且有指标的Pod,未就绪的Pod也计入,避免扩容后立即再扩
+	var used, requested [2]int64
+	names := []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory}
+	u := &Utilization{}
+	for _, pod := range pods {
+		usage, ok := usages[pod.Name]
+		if !ok || pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+			continue
+		}
+		u.Pods++
+		requests := podSpecRequests(&pod.Spec)
+		for i, name := range names {
+			q, r := usage[name], requests[name]
+			used[i] += q.MilliValue()
+			requested[i] += r.MilliValue()
+		}
+	}
+	if u.Pods == 0 {
+		return nil, fmt.Errorf("no metrics for %s", target)
+	}
+	percent := func(i int) float64 {
+		if requested[i] == 0 {
+			return -1
+		}
+		return float64(used[i]) * 100 / float64(requested[i])
+	}
+	u.CPU, u.Memory = percent(0), percent(1)
+	return u, nil
+}
+
+// SetResourceTargets 服务的CPU、内存目标利用率,都为0时只按QPS伸缩
+func (sm *ScalerManage) SetResourceTargets(serviceName string, targets ResourceTargets) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	if targets.CPU <= 0 && targets.Memory <= 0 {
+		delete(sm.resourceTargets, serviceName)
+		return
+	}
+	sm.resourceTargets[serviceName] = targets
+}
+
+// recommendByResources 按CPU、内存利用率计算服务的副本总数,每个工作负载为ceil(副本数*利用率/目标)。
+// 没有设置目标或读取不到指标时返回nil,只按QPS伸缩
+func (sm *ScalerManage) recommendByResources(serviceName string) *Recommendation {
+	sm.mutex.Lock()
+	goal, ok := sm.resourceTargets[serviceName]
+	sm.mutex.Unlock()
+	if !ok {
+		return nil
+	}
+	reader, ok := sm.backend(serviceName).(UtilizationReader)
+	if !ok {
+		return nil
+	}
+	targets, err := sm.resolve(serviceName)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	// 试运行时以模拟的副本数为准,与ChangeServicePod一致
+	scaler, _ := sm.scaler(serviceName)
+	rec := &Recommendation{}
+	reasons := make([]string, 0)
+	usable := false
+	for _, target := range targets {
+		current, err := scaler.GetServicePod(target)
+		if err != nil {
+			log.Printf("get %s(%s) pod error %v", serviceName, target, err)
+			return nil
+		}
+		u, err := reader.Utilization(target)
+		if err != nil {
+			log.Printf("get %s(%s) utilization error %v", serviceName, target, err)
+			return nil
+		}
+		// 每种资源按利用率计算,取较大的
+		desired := int32(-1)
+		for _, item := range []struct {
+			name          string
+			used, percent float64
+		}{{"cpu", u.CPU, float64(goal.CPU)}, {"memory", u.Memory, float64(goal.Memory)}} {
+			if item.percent <= 0 || item.used < 0 {
+				continue
+			}
+			reasons = append(reasons, fmt.Sprintf("%s %s %.0f%%/%.0f%%", target.Name, item.name, item.used, item.percent))
+			cnt := *current
+			if ratio := item.used / item.percent; math.Abs(ratio-1) > utilizationTolerance {
+				cnt = int32(math.Ceil(float64(*current) * ratio))
+			}
+			if cnt > desired {
+				desired = cnt
+			}
+		}
+		if desired < 0 {
+			// Pod没有设置对应资源的requests
+			desired = *current
+		} else {
+			usable = true
+		}
+		rec.Current += *current
+		rec.Desired += desired
+	}
+	if !usable {
+		return nil
+	}
+	rec.Reason = strings.Join(reasons, ", ")
+	return rec
+}
+
+// Recommend 按QPS计算的副本数byQps与按CPU、内存利用率计算的取较大值,没有使用资源指标时rec为nil
+func (sm *ScalerManage) Recommend(serviceName string, byQps int32) (int32, *Recommendation) {
+	rec := sm.recommendByResources(serviceName)
+	if rec == nil || rec.Desired <= byQps {
+		return byQps, rec
+	}
+	return rec.Desired, rec
+}
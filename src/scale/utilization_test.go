@@ -0,0 +1,80 @@
+package scale
+
+import (
+	"testing"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	metricsfake "k8s.io/metrics/pkg/client/clientset/versioned/fake"
+)
+
+func TestScalerManage_Recommend(t *testing.T) {
+	objects := make([]runtime.Object, 0)
+	usages := make([]metricsv1beta1.PodMetrics, 0)
+	for i, cpu := range []string{"450m", "350m"} {
+		name := []string{"web-a", "web-b"}[i]
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "demo", Labels: map[string]string{"app": "web"}},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+			}}}},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		})
+		usages = append(usages, metricsv1beta1.PodMetrics{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "demo", Labels: map[string]string{"app": "web"}},
+			Containers: []metricsv1beta1.ContainerMetrics{{Usage: corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse(cpu), corev1.ResourceMemory: resource.MustParse("100Mi"),
+			}}},
+		})
+	}
+	replicas := map[string]int32{"deployments/demo/web": 2}
+	kc, scales := newFakeK8SClient(replicas, objects...)
+	scales.PrependReactor("get", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &autoscalingv1.Scale{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "demo"},
+			Spec:       autoscalingv1.ScaleSpec{Replicas: replicas["deployments/demo/web"]},
+			Status:     autoscalingv1.ScaleStatus{Replicas: 2, Selector: "app=web"},
+		}, nil
+	})
+	metrics := metricsfake.NewSimpleClientset()
+	// fake中PodMetrics的resource为pods,与tracker按Kind推断的不同,直接返回
+	metrics.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &metricsv1beta1.PodMetricsList{Items: usages}, nil
+	})
+	kc.metrics = metrics
+
+	sm := NewScaler(3, 60, kc)
+	sm.SetTargets("web.demo", []*Target{NewTarget("", "", "demo", "web")})
+	if wants, rec := sm.Recommend("web.demo", 1); rec != nil || wants != 1 {
+		t.Fatalf("want QPS only without targets, got %d %v", wants, rec)
+	}
+	// CPU利用率80%,目标50%: ceil(2*1.6)=4
+	sm.SetResourceTargets("web.demo", ResourceTargets{CPU: 50})
+	wants, rec := sm.Recommend("web.demo", 1)
+	if rec == nil || rec.Current != 2 || rec.Desired != 4 || wants != 4 {
+		t.Fatalf("want 4 by cpu, got %d %+v", wants, rec)
+	}
+	if wants, _ = sm.Recommend("web.demo", 6); wants != 6 {
+		t.Errorf("want QPS 6 when higher, got %d", wants)
+	}
+	// 试运行时按模拟的副本数计算: ceil(3*1.6)=5
+	sm.SetDryRun("web.demo", true)
+	newCount := int32(3)
+	if _, err := sm.ChangeServicePod("web.demo", &newCount, nil); err != nil || replicas["deployments/demo/web"] != 2 {
+		t.Fatalf("want dry-run change only, got %v %v", replicas, err)
+	}
+	if wants, rec = sm.Recommend("web.demo", 1); rec == nil || rec.Current != 3 || wants != 5 {
+		t.Fatalf("want 5 from simulated replicas, got %d %+v", wants, rec)
+	}
+	sm.SetDryRun("web.demo", false)
+	// 内存没有requests,不参与计算
+	sm.SetResourceTargets("web.demo", ResourceTargets{Memory: 50})
+	if wants, rec = sm.Recommend("web.demo", 1); rec != nil || wants != 1 {
+		t.Errorf("want QPS only without memory requests, got %d %+v", wants, rec)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 
 	"gopkg.in/yaml.v2"
 )
@@ -15,8 +16,31 @@ const (
 	defaultAvgTime      = 5
 	defaultIntervalTime = 120
 	defaultIngressType  = "nginx"
+	defaultHttpPort     = 6060
 	defaultMinPod       = 1
 	defaultFact         = 1
+	defaultAPIVersion   = "apps/v1"
+	defaultKind         = "Deployment"
+	defaultLeaseName    = "simple-hpa"
+	defaultLeaseNs      = "default"
+	defaultIdleTime     = 1800
+	defaultManualChange = "hold"
+	defaultManualGrace  = 600
+	defaultSuppress     = 1800
+	defaultPlaceholder  = "simple-hpa-placeholder"
+	defaultPlaceholders = 10
+	defaultGrowthWindow = 1800
+	defaultResizePeriod = 60
+
+	// 伸缩后端
+	BackendKubernetes = "kubernetes"
+	BackendExec       = "exec"
+	BackendWebhook    = "webhook"
+
+	// 运行模式
+	ModeStandalone = "standalone"
+	ModeAgent      = "agent"      // 在ingress节点解析日志,把计数推给aggregator
+	ModeAggregator = "aggregator" // 接收agent的计数并伸缩
 )
 
 type DefaultConfig struct {
@@ -27,6 +51,15 @@ type DefaultConfig struct {
 	MaxQps            float32 `yaml:"maxQps"`
 	SafeQps           float32 `yaml:"safeQps"`
 	Factor            float32 `yaml:"factor"`
+	// 试运行,只记录、通知本应执行的伸缩,不修改副本数
+	DryRun bool `yaml:"dryRun"`
+	// 就绪不到warmUp秒的Pod不计入每Pod的平均QPS,0为不排除
+	WarmUp int `yaml:"warmUp"`
+	// 发现副本数被其他人修改时:hold在manualGrace秒内不伸缩,floor把修改后的值作为下限,ignore不处理
+	ManualChange string `yaml:"manualChange"`
+	ManualGrace  int    `yaml:"manualGrace"`
+	// 缩容前按最近的请求数设置Pod的controller.kubernetes.io/pod-deletion-cost,空闲的Pod先被删除
+	DeletionCost bool `yaml:"deletionCost"`
 }
 
 func newScaleConfig(namespace, svc, minPod, maxPod, safeQps, maxQps, factor string) *scaleServiceConfig {
@@ -51,13 +84,13 @@ func newScaleConfig(namespace, svc, minPod, maxPod, safeQps, maxQps, factor stri
 		log.Fatalln(err)
 	}
 	return &scaleServiceConfig{
-		namespace,
-		svc,
-		int32(_maxPod),
-		int32(_minPod),
-		float32(_maxQps),
-		float32(_safeQps),
-		float32(_factor),
+		Namespace:   namespace,
+		ServiceName: svc,
+		MaxPod:      int32(_maxPod),
+		MinPod:      int32(_minPod),
+		MaxQps:      float32(_maxQps),
+		SafeQps:     float32(_safeQps),
+		Factor:      float32(_factor),
 	}
 }
 
@@ -69,6 +102,110 @@ type scaleServiceConfig struct {
 	MaxQps      float32 `yaml:"maxQps"`
 	SafeQps     float32 `yaml:"safeQps"`
 	Factor      float32 `yaml:"factor"`
+	// 伸缩的工作负载类型,需要实现/scale子资源,等同于只设置了apiVersion和kind的targetRef
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	// 指定伸缩的工作负载,未指定时通过Service的selector查找
+	TargetRef *targetRefConfig `yaml:"targetRef"`
+	// 一个Service对应多个工作负载(如金丝雀发布)时一起伸缩,副本总数按weight分配
+	TargetRefs []*targetRefConfig `yaml:"targetRefs"`
+	// 未设置时使用default.dryRun
+	DryRun *bool `yaml:"dryRun"`
+	// 设置后minPod可以为0: 超过idleTime秒没有请求才缩到0,缩到0后收到请求立即扩到minActivePod
+	MinActivePod int32 `yaml:"minActivePod"`
+	IdleTime     int   `yaml:"idleTime"`
+	// 未设置时使用default.warmUp
+	WarmUp int `yaml:"warmUp"`
+	// 不在Kubernetes中的服务通过命令或webhook伸缩,未设置时使用Kubernetes
+	Backend *backendConfig `yaml:"backend"`
+	// 工作负载所在的集群,为clusters中的name,未设置时使用默认集群
+	Cluster string `yaml:"cluster"`
+	// 超过budget时优先级高的服务先分配,默认0
+	Priority int `yaml:"priority"`
+	// CPU、内存的目标利用率,为Pod requests的百分比。设置后按metrics.k8s.io的指标计算副本数,与按QPS计算的取较大值
+	TargetCPUUtilization    int32 `yaml:"targetCPUUtilization"`
+	TargetMemoryUtilization int32 `yaml:"targetMemoryUtilization"`
+	// 通过注解发现的服务,不是来自配置文件
+	Discovered bool `yaml:"-"`
+}
+
+// budgetLimit 副本数和CPU核数的上限,0为不限制
+type budgetLimit struct {
+	MaxPods int32   `yaml:"maxPods"`
+	MaxCPU  float64 `yaml:"maxCpu"`
+}
+
+// budgetConfig 所有服务加起来的上限,namespaces中可以为每个namespace单独设置
+type budgetConfig struct {
+	budgetLimit `yaml:",inline"`
+	Namespaces  map[string]budgetLimit `yaml:"namespaces"`
+}
+
+// groupConfig leader伸缩时followers按leader的期望副本数一起伸缩,leader和follower都为serviceName.namespace
+type groupConfig struct {
+	Name      string            `yaml:"name"`
+	Leader    string            `yaml:"leader"`
+	Followers []*followerConfig `yaml:"followers"`
+}
+
+// followerConfig ratio和formula二选一,formula中用leader表示leader的期望副本数,结果向上取整
+type followerConfig struct {
+	Service string  `yaml:"service"`
+	Ratio   float64 `yaml:"ratio"`
+	Formula string  `yaml:"formula"`
+	MinPod  int32   `yaml:"minPod"`
+	MaxPod  int32   `yaml:"maxPod"`
+}
+
+// regressionConfig 缩容后window秒内5xx比例比缩容前高出errorRate,或平均延迟超过缩容前的latencyRatio倍时,
+// 恢复缩容前的副本数,suppress秒内不再缩容。window为0时不检查
+type regressionConfig struct {
+	Window       int     `yaml:"window"`
+	ErrorRate    float64 `yaml:"errorRate"`
+	LatencyRatio float64 `yaml:"latencyRatio"`
+	Suppress     int     `yaml:"suppress"`
+}
+
+// overprovisionConfig 按预计需要的扩容空间调整低优先级占位Deployment的副本数,
+// 让cluster autoscaler提前准备节点,真正的Pod会抢占占位Pod
+type overprovisionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 占位Deployment,默认为leaseNamespace下的simple-hpa-placeholder
+	Namespace string `yaml:"namespace"`
+	Name      string `yaml:"name"`
+	MinPods   int32  `yaml:"minPods"`
+	MaxPods   int32  `yaml:"maxPods"`
+	// 统计副本数增长的时间范围,单位秒
+	Window int `yaml:"window"`
+	// 调整占位副本数的最小间隔,单位秒
+	Interval int `yaml:"interval"`
+}
+
+// clusterConfig 多集群时其他集群的kubeconfig和context
+type clusterConfig struct {
+	Name       string `yaml:"name"`
+	Kubeconfig string `yaml:"kubeconfig"`
+	Context    string `yaml:"context"`
+}
+
+// backendConfig type为exec时执行get、set命令,为webhook时调用url
+type backendConfig struct {
+	Type  string `yaml:"type"`
+	Get   string `yaml:"get"`
+	Set   string `yaml:"set"`
+	URL   string `yaml:"url"`
+	Token string `yaml:"token"`
+}
+
+type targetRefConfig struct {
+	APIVersion string  `yaml:"apiVersion"`
+	Kind       string  `yaml:"kind"`
+	Name       string  `yaml:"name"`
+	Weight     float64 `yaml:"weight"`
+}
+
+func (trc *targetRefConfig) String() string {
+	return fmt.Sprintf("%s/%s %s", trc.APIVersion, trc.Kind, trc.Name)
 }
 
 func (ssc *scaleServiceConfig) String() string {
@@ -78,6 +215,24 @@ func (ssc *scaleServiceConfig) String() string {
 type listenConfig struct {
 	ListenAddr string `yaml:"address"`
 	Port       int    `yaml:"port"`
+	// 指标等HTTP接口的端口
+	HttpPort int `yaml:"httpPort"`
+}
+
+// haConfig 多副本部署,通过Lease选出leader执行伸缩
+type haConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	LeaseName      string `yaml:"leaseName"`
+	LeaseNamespace string `yaml:"leaseNamespace"`
+	// 其他副本访问本副本HTTP端口的地址,默认为主机名:httpPort
+	Address string `yaml:"address"`
+}
+
+// adminConfig 暂停、固定副本数等管理接口,在httpPort的/admin/下
+type adminConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 不为空时请求需要带Authorization: Bearer <token>
+	Token string `yaml:"token"`
 }
 
 type ForwardConfig struct {
@@ -92,6 +247,29 @@ type Config struct {
 	Forwards      []ForwardConfig       `yaml:"forwards"`
 	Notifies      []notifyConfig        `yaml:"notifies"`
 	ScaleServices []*scaleServiceConfig `yaml:"scaleServices"`
+	// 监听带有simple-hpa.io/enabled注解的Deployment和Service,自动加入伸缩
+	Discovery bool `yaml:"discovery"`
+	// 监听SimpleHPA自定义资源,自动加入伸缩并回写status
+	Controller bool        `yaml:"controller"`
+	HA         haConfig    `yaml:"ha"`
+	Admin      adminConfig `yaml:"admin"`
+	// standalone、agent或aggregator,默认standalone
+	Mode string `yaml:"mode"`
+	// agent模式下aggregator的HTTP地址
+	Aggregator string `yaml:"aggregator"`
+	// 其他集群,scaleServices中通过cluster指定
+	Clusters []clusterConfig `yaml:"clusters"`
+	// 启动时有服务缺少RBAC权限则退出,否则只标记为degraded
+	RBACFailFast bool `yaml:"rbacFailFast"`
+	// 期望副本数之和超过预算时按priority和需求比例分配
+	Budget budgetConfig `yaml:"budget"`
+	// 联动伸缩的服务分组,leader需要在scaleServices中
+	Groups []*groupConfig `yaml:"groups"`
+	// 缩容后错误率或延迟变差时自动回滚
+	Regression regressionConfig `yaml:"regression"`
+	// 用占位Pod为预计的扩容提前准备节点
+	Overprovision overprovisionConfig `yaml:"overprovision"`
+	mutex         sync.RWMutex
 }
 
 func (c *Config) String() string {
@@ -99,6 +277,8 @@ func (c *Config) String() string {
 }
 
 func (c *Config) GetServiceConfig(service string) *scaleServiceConfig {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	for _, _conf := range c.ScaleServices {
 		svcName := fmt.Sprintf("%s.%s", _conf.ServiceName, _conf.Namespace)
 		if svcName == service {
@@ -108,6 +288,18 @@ func (c *Config) GetServiceConfig(service string) *scaleServiceConfig {
 	return nil
 }
 
+// GetGroupConfig 以service为leader的分组
+func (c *Config) GetGroupConfig(leader string) *groupConfig {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for _, group := range c.Groups {
+		if group.Leader == leader {
+			return group
+		}
+	}
+	return nil
+}
+
 func (c *Config) valid() {
 	if c.Default.MaxQps < c.Default.SafeQps {
 		log.Fatalln("config error, default.maxQPS < default.safeQPS")
@@ -127,6 +319,30 @@ func (c *Config) valid() {
 		c.Default.ScaleIntervalTime = defaultIntervalTime
 		log.Println("INFO, default.scaleIntervalTime use default ", defaultIntervalTime)
 	}
+	if c.Listen.HttpPort <= 0 {
+		c.Listen.HttpPort = defaultHttpPort
+	}
+	if c.HA.LeaseName == "" {
+		c.HA.LeaseName = defaultLeaseName
+	}
+	if c.HA.LeaseNamespace == "" {
+		c.HA.LeaseNamespace = defaultLeaseNs
+	}
+	if c.HA.Address == "" {
+		hostname, _ := os.Hostname()
+		c.HA.Address = fmt.Sprintf("%s:%d", hostname, c.Listen.HttpPort)
+	}
+	switch c.Mode {
+	case "":
+		c.Mode = ModeStandalone
+	case ModeStandalone, ModeAggregator:
+	case ModeAgent:
+		if c.Aggregator == "" {
+			log.Fatalln("config error, aggregator address is required in agent mode")
+		}
+	default:
+		log.Fatalln("config error, unknown mode", c.Mode)
+	}
 	if c.IngressType == "" {
 		c.IngressType = defaultIngressType
 		log.Println("INFO config ingressType use default ", defaultIngressType)
@@ -134,39 +350,229 @@ func (c *Config) valid() {
 	if c.Default.Factor <= 0 {
 		c.Default.Factor = defaultFact
 	}
+	switch c.Default.ManualChange {
+	case "":
+		c.Default.ManualChange = defaultManualChange
+	case "hold", "floor", "ignore":
+	default:
+		log.Fatalln("config error, default.manualChange should be hold, floor or ignore")
+	}
+	if c.Default.ManualGrace <= 0 {
+		c.Default.ManualGrace = defaultManualGrace
+	}
+	if c.Regression.Window > 0 {
+		if c.Regression.ErrorRate <= 0 && c.Regression.LatencyRatio <= 0 {
+			log.Fatalln("config error, regression needs errorRate or latencyRatio")
+		}
+		if c.Regression.Suppress <= 0 {
+			c.Regression.Suppress = defaultSuppress
+		}
+	}
+	c.validOverprovision()
 	if c.ScaleServices == nil {
 		c.ScaleServices = make([]*scaleServiceConfig, 0)
 		log.Println("WARN config scaleServices not present,this mean nothing to do")
 	}
+	for _, cluster := range c.Clusters {
+		if cluster.Name == "" || (cluster.Kubeconfig == "" && cluster.Context == "") {
+			log.Fatalln("config error, clusters need name and kubeconfig or context")
+		}
+	}
 	for _, scaleConfig := range c.ScaleServices {
-		if scaleConfig.MinPod <= 0 {
-			scaleConfig.MinPod = c.Default.MinPod
+		if err := c.validService(scaleConfig); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if err := c.validGroups(); err != nil {
+		log.Fatalln(err)
+	}
+	if c.Forwards == nil {
+		c.Forwards = make([]ForwardConfig, 0)
+	}
+}
+
+// validService 未设置的项使用默认值
+func (c *Config) validService(scaleConfig *scaleServiceConfig) error {
+	// 设置了minActivePod时minPod可以为0
+	if scaleConfig.MinPod < 0 || (scaleConfig.MinPod == 0 && scaleConfig.MinActivePod <= 0) {
+		scaleConfig.MinPod = c.Default.MinPod
+	}
+	if scaleConfig.MaxPod <= 0 {
+		scaleConfig.MaxPod = c.Default.MaxPod
+	}
+	if scaleConfig.MaxPod < scaleConfig.MinPod {
+		return fmt.Errorf("%s config err, MaxPod < MinPod", scaleConfig.ServiceName)
+	}
+	if scaleConfig.MinActivePod > 0 {
+		if scaleConfig.MinActivePod < scaleConfig.MinPod || scaleConfig.MinActivePod > scaleConfig.MaxPod {
+			return fmt.Errorf("%s config err, MinActivePod not in [MinPod, MaxPod]", scaleConfig.ServiceName)
+		}
+		if scaleConfig.IdleTime <= 0 {
+			scaleConfig.IdleTime = defaultIdleTime
+		}
+	}
+	if scaleConfig.MaxQps <= 0 {
+		scaleConfig.MaxQps = c.Default.MaxQps
+	}
+	if scaleConfig.SafeQps <= 0 {
+		scaleConfig.SafeQps = c.Default.SafeQps
+	}
+	if scaleConfig.MaxQps < scaleConfig.SafeQps {
+		return fmt.Errorf("%s config err, MaxQps < SafeQps", scaleConfig.ServiceName)
+	}
+	if scaleConfig.Factor <= 0 {
+		scaleConfig.Factor = c.Default.Factor
+	}
+	if scaleConfig.TargetCPUUtilization < 0 || scaleConfig.TargetMemoryUtilization < 0 {
+		return fmt.Errorf("%s config err, target utilization < 0", scaleConfig.ServiceName)
+	}
+	if err := validBackend(scaleConfig); err != nil {
+		return err
+	}
+	if scaleConfig.Cluster != "" && !c.hasCluster(scaleConfig.Cluster) {
+		return fmt.Errorf("%s config err, cluster %s not in clusters", scaleConfig.ServiceName, scaleConfig.Cluster)
+	}
+	if scaleConfig.WarmUp <= 0 {
+		scaleConfig.WarmUp = c.Default.WarmUp
+	}
+	if scaleConfig.TargetRef == nil && (scaleConfig.APIVersion != "" || scaleConfig.Kind != "") {
+		scaleConfig.TargetRef = &targetRefConfig{APIVersion: scaleConfig.APIVersion, Kind: scaleConfig.Kind}
+	}
+	if scaleConfig.DryRun == nil {
+		dryRun := c.Default.DryRun
+		scaleConfig.DryRun = &dryRun
+	}
+	if scaleConfig.TargetRef != nil {
+		scaleConfig.TargetRefs = append([]*targetRefConfig{scaleConfig.TargetRef}, scaleConfig.TargetRefs...)
+	}
+	for _, ref := range scaleConfig.TargetRefs {
+		if ref.APIVersion == "" {
+			ref.APIVersion = defaultAPIVersion
+		}
+		if ref.Kind == "" {
+			ref.Kind = defaultKind
+		}
+		if ref.Name == "" {
+			ref.Name = scaleConfig.ServiceName
 		}
-		if scaleConfig.MaxPod <= 0 {
-			scaleConfig.MaxPod = c.Default.MaxPod
+		if ref.Weight < 0 {
+			return fmt.Errorf("%s config err, targetRef %s weight < 0", scaleConfig.ServiceName, ref.Name)
 		}
-		if scaleConfig.MinPod <= 0 {
-			scaleConfig.MinPod = c.Default.MinPod
+	}
+	return nil
+}
+
+func validBackend(scaleConfig *scaleServiceConfig) error {
+	backend := scaleConfig.Backend
+	if backend == nil {
+		return nil
+	}
+	switch backend.Type {
+	case "", BackendKubernetes:
+		scaleConfig.Backend = nil
+	case BackendExec:
+		if backend.Get == "" || backend.Set == "" {
+			return fmt.Errorf("%s config err, exec backend needs get and set", scaleConfig.ServiceName)
 		}
-		if scaleConfig.MaxPod < scaleConfig.MinPod {
-			log.Fatalln(fmt.Sprintf("%s config err, MaxPod < MinPod", scaleConfig.ServiceName))
+	case BackendWebhook:
+		if backend.URL == "" {
+			return fmt.Errorf("%s config err, webhook backend needs url", scaleConfig.ServiceName)
+		}
+	default:
+		return fmt.Errorf("%s config err, unknown backend %s", scaleConfig.ServiceName, backend.Type)
+	}
+	return nil
+}
+
+func (c *Config) validOverprovision() {
+	op := &c.Overprovision
+	if !op.Enabled {
+		return
+	}
+	if op.Namespace == "" {
+		op.Namespace = c.HA.LeaseNamespace
+	}
+	if op.Name == "" {
+		op.Name = defaultPlaceholder
+	}
+	if op.MaxPods <= 0 {
+		op.MaxPods = defaultPlaceholders
+	}
+	if op.MinPods < 0 || op.MinPods > op.MaxPods {
+		log.Fatalln("config error, overprovision.minPods not in [0, maxPods]")
+	}
+	if op.Window <= 0 {
+		op.Window = defaultGrowthWindow
+	}
+	if op.Interval <= 0 {
+		op.Interval = defaultResizePeriod
+	}
+}
+
+// validGroups follower只能属于一个分组,且不能自己按流量伸缩,否则两边会互相覆盖
+func (c *Config) validGroups() error {
+	services := make(map[string]bool, len(c.ScaleServices))
+	for _, scaleConfig := range c.ScaleServices {
+		services[fmt.Sprintf("%s.%s", scaleConfig.ServiceName, scaleConfig.Namespace)] = true
+	}
+	leaders := make(map[string]bool, len(c.Groups))
+	followers := make(map[string]bool)
+	for _, group := range c.Groups {
+		if group.Name == "" {
+			group.Name = group.Leader
 		}
-		if scaleConfig.MaxQps <= 0 {
-			scaleConfig.MaxQps = c.Default.MaxQps
+		if !services[group.Leader] {
+			return fmt.Errorf("group %s config err, leader %s not in scaleServices", group.Name, group.Leader)
 		}
-		if scaleConfig.SafeQps <= 0 {
-			scaleConfig.SafeQps = c.Default.SafeQps
+		if leaders[group.Leader] {
+			return fmt.Errorf("group %s config err, %s leads another group", group.Name, group.Leader)
 		}
-		if scaleConfig.MaxQps < scaleConfig.SafeQps {
-			log.Fatalln(fmt.Sprintf("%s config err, MaxQps < SafeQps", scaleConfig.ServiceName))
+		leaders[group.Leader] = true
+		for _, follower := range group.Followers {
+			if len(strings.Split(follower.Service, ".")) != 2 {
+				return fmt.Errorf("group %s config err, follower %q should be serviceName.namespace", group.Name, follower.Service)
+			}
+			if services[follower.Service] || followers[follower.Service] {
+				return fmt.Errorf("group %s config err, %s is already scaled by traffic or another group", group.Name, follower.Service)
+			}
+			followers[follower.Service] = true
+			if (follower.Ratio > 0) == (follower.Formula != "") {
+				return fmt.Errorf("group %s config err, follower %s needs one of ratio and formula", group.Name, follower.Service)
+			}
+			if follower.MinPod <= 0 {
+				follower.MinPod = c.Default.MinPod
+			}
+			if follower.MaxPod <= 0 {
+				follower.MaxPod = c.Default.MaxPod
+			}
+			if follower.MaxPod < follower.MinPod {
+				return fmt.Errorf("group %s config err, follower %s MaxPod < MinPod", group.Name, follower.Service)
+			}
 		}
-		if scaleConfig.Factor <= 0 {
-			scaleConfig.Factor = c.Default.Factor
+	}
+	return nil
+}
+
+func (c *Config) hasCluster(name string) bool {
+	for _, cluster := range c.Clusters {
+		if cluster.Name == name {
+			return true
 		}
 	}
-	if c.Forwards == nil {
-		c.Forwards = make([]ForwardConfig, 0)
+	return false
+}
+
+// NeedKubernetes 只有所有服务都使用exec或webhook,且没有开启依赖Kubernetes的功能时才不连接Kubernetes
+func (c *Config) NeedKubernetes() bool {
+	if c.Discovery || c.Controller || c.HA.Enabled || c.Overprovision.Enabled {
+		return true
 	}
+	for _, scaleConfig := range c.ScaleServices {
+		if scaleConfig.Backend == nil {
+			return true
+		}
+	}
+	return false
 }
 
 func (c *Config) getEnvConfig() {
@@ -205,6 +611,49 @@ func (c *Config) getEnvConfig() {
 	} else {
 		log.Printf("WARN AVG_TIME env is %d it's not valid, use config.yaml value %d", avgTime, c.Default.AvgTime)
 	}
+	dryRun, err := strconv.ParseBool(os.Getenv("DRY_RUN"))
+	if err == nil {
+		c.Default.DryRun = dryRun
+	}
+	discovery, err := strconv.ParseBool(os.Getenv("DISCOVERY"))
+	if err == nil {
+		c.Discovery = discovery
+	}
+	controller, err := strconv.ParseBool(os.Getenv("CONTROLLER"))
+	if err == nil {
+		c.Controller = controller
+	}
+	admin, err := strconv.ParseBool(os.Getenv("ADMIN"))
+	if err == nil {
+		c.Admin.Enabled = admin
+	}
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		c.Admin.Token = token
+	}
+	if failFast, err := strconv.ParseBool(os.Getenv("RBAC_FAIL_FAST")); err == nil {
+		c.RBACFailFast = failFast
+	}
+	if mode := os.Getenv("MODE"); mode != "" {
+		c.Mode = mode
+	}
+	if aggregator := os.Getenv("AGGREGATOR"); aggregator != "" {
+		c.Aggregator = aggregator
+	}
+	ha, err := strconv.ParseBool(os.Getenv("HA"))
+	if err == nil {
+		c.HA.Enabled = ha
+	}
+	// 通过downward API注入
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		c.HA.LeaseNamespace = namespace
+	}
+	if podIP := os.Getenv("POD_IP"); podIP != "" {
+		httpPort := c.Listen.HttpPort
+		if httpPort <= 0 {
+			httpPort = defaultHttpPort
+		}
+		c.HA.Address = fmt.Sprintf("%s:%d", podIP, httpPort)
+	}
 	ingressType := os.Getenv("INGRESS_TYPE")
 	if ingressType != "" {
 		c.IngressType = ingressType
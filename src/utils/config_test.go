@@ -13,3 +13,118 @@ func TestNewConfig(t *testing.T) {
 	log.Println(config.ScaleServices, config.Default.MaxPod, config.Default.AvgTime)
 	log.Println(config.Notifies)
 }
+
+func TestConfig_validTargetRef(t *testing.T) {
+	config := &Config{
+		Default: &DefaultConfig{MaxPod: 2, MinPod: 1, MaxQps: 10, SafeQps: 5},
+		ScaleServices: []*scaleServiceConfig{
+			{ServiceName: "web", Namespace: "demo"},
+			{ServiceName: "db", Namespace: "demo", Kind: "StatefulSet"},
+			{ServiceName: "api", Namespace: "demo", TargetRef: &targetRefConfig{Name: "api-v2"}},
+		},
+	}
+	config.valid()
+	if len(config.ScaleServices[0].TargetRefs) != 0 {
+		t.Errorf("want targetRef resolved by selector, got %s", config.ScaleServices[0].TargetRefs)
+	}
+	if refs := config.ScaleServices[1].TargetRefs; len(refs) != 1 || refs[0].String() != "apps/v1/StatefulSet db" {
+		t.Errorf("want apps/v1/StatefulSet db, got %v", refs)
+	}
+	if ref := config.ScaleServices[2].TargetRefs[0]; ref.String() != "apps/v1/Deployment api-v2" {
+		t.Errorf("want apps/v1/Deployment api-v2, got %s", ref)
+	}
+}
+
+func TestConfig_validDryRun(t *testing.T) {
+	live := false
+	config := &Config{
+		Default: &DefaultConfig{MaxPod: 2, MinPod: 1, MaxQps: 10, SafeQps: 5, DryRun: true},
+		ScaleServices: []*scaleServiceConfig{
+			{ServiceName: "web", Namespace: "demo"},
+			{ServiceName: "api", Namespace: "demo", DryRun: &live},
+		},
+	}
+	config.valid()
+	if !*config.ScaleServices[0].DryRun || *config.ScaleServices[1].DryRun {
+		t.Errorf("want web dry-run and api live, got %t %t",
+			*config.ScaleServices[0].DryRun, *config.ScaleServices[1].DryRun)
+	}
+}
+
+func TestConfig_NeedKubernetes(t *testing.T) {
+	config := &Config{
+		Default: &DefaultConfig{MaxPod: 2, MinPod: 1, MaxQps: 10, SafeQps: 5},
+		ScaleServices: []*scaleServiceConfig{
+			{ServiceName: "web", Namespace: "compose", Backend: &backendConfig{Type: BackendExec, Get: "echo 1", Set: "true"}},
+			{ServiceName: "api", Namespace: "vm", Backend: &backendConfig{Type: BackendWebhook, URL: "http://asg/replicas"}},
+		},
+	}
+	config.valid()
+	if config.NeedKubernetes() {
+		t.Error("exec and webhook services should not need kubernetes")
+	}
+	config.Overprovision.Enabled = true
+	if !config.NeedKubernetes() {
+		t.Error("overprovision needs kubernetes")
+	}
+	config.Overprovision.Enabled = false
+	config.ScaleServices = append(config.ScaleServices, &scaleServiceConfig{ServiceName: "db", Namespace: "demo",
+		Backend: &backendConfig{Type: BackendKubernetes}})
+	config.valid()
+	if !config.NeedKubernetes() {
+		t.Error("kubernetes service needs kubernetes")
+	}
+	if err := validBackend(&scaleServiceConfig{Backend: &backendConfig{Type: BackendExec, Get: "echo 1"}}); err == nil {
+		t.Error("want missing set command error")
+	}
+}
+
+func TestConfig_validCluster(t *testing.T) {
+	config := &Config{
+		Default:  &DefaultConfig{MaxPod: 2, MinPod: 1, MaxQps: 10, SafeQps: 5},
+		Clusters: []clusterConfig{{Name: "east", Context: "east"}},
+	}
+	if err := config.validService(&scaleServiceConfig{ServiceName: "web", Cluster: "east"}); err != nil {
+		t.Error(err)
+	}
+	if err := config.validService(&scaleServiceConfig{ServiceName: "web", Cluster: "west"}); err == nil {
+		t.Error("want unknown cluster error")
+	}
+}
+
+func TestConfig_validGroups(t *testing.T) {
+	config := &Config{
+		Default:       &DefaultConfig{MaxPod: 2, MinPod: 1, MaxQps: 10, SafeQps: 5},
+		ScaleServices: []*scaleServiceConfig{{ServiceName: "web", Namespace: "shop"}},
+		Groups: []*groupConfig{{Leader: "web.shop", Followers: []*followerConfig{
+			{Service: "api.shop", Ratio: 0.5, MaxPod: 20},
+			{Service: "worker.shop", Formula: "leader / 3 + 1"},
+		}}},
+	}
+	if err := config.validGroups(); err != nil {
+		t.Fatal(err)
+	}
+	if group := config.GetGroupConfig("web.shop"); group == nil || group.Name != "web.shop" || group.Followers[1].MaxPod != 2 {
+		t.Errorf("want group named by leader with default maxPod, got %+v", group)
+	}
+	config.Groups[0].Followers = append(config.Groups[0].Followers, &followerConfig{Service: "web.shop", Ratio: 1})
+	if err := config.validGroups(); err == nil {
+		t.Error("want error for a follower scaled by traffic")
+	}
+	config.Groups[0].Followers = []*followerConfig{{Service: "api.shop", Ratio: 1, Formula: "leader"}}
+	if err := config.validGroups(); err == nil {
+		t.Error("want error for both ratio and formula")
+	}
+}
+
+func TestConfig_validOverprovision(t *testing.T) {
+	config := &Config{
+		Default:       &DefaultConfig{MaxPod: 2, MinPod: 1, MaxQps: 10, SafeQps: 5},
+		HA:            haConfig{LeaseNamespace: "simple-hpa"},
+		Overprovision: overprovisionConfig{Enabled: true, MinPods: 1},
+	}
+	config.validOverprovision()
+	if op := config.Overprovision; op.Namespace != "simple-hpa" || op.Name != defaultPlaceholder || op.MaxPods != defaultPlaceholders {
+		t.Errorf("want defaults in the lease namespace, got %+v", op)
+	}
+}
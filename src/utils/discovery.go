@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	annotationPrefix  = "simple-hpa.io/"
+	AnnotationEnabled = annotationPrefix + "enabled"
+	// Deployment上的注解,指定Ingress日志中对应的Service,默认与Deployment同名
+	AnnotationService = annotationPrefix + "service"
+	annotationMaxQps  = annotationPrefix + "max-qps"
+	annotationSafeQps = annotationPrefix + "safe-qps"
+	annotationMinPod  = annotationPrefix + "min-pod"
+	annotationMaxPod  = annotationPrefix + "max-pod"
+	annotationFactor  = annotationPrefix + "factor"
+)
+
+// ServiceSpec 配置文件之外(注解、SimpleHPA)定义的服务伸缩配置,未设置的项使用default
+type ServiceSpec struct {
+	Namespace   string
+	ServiceName string
+	// 不为空时直接伸缩该工作负载,否则通过Service的selector查找
+	APIVersion string
+	Kind       string
+	Name       string
+	MinPod     int32
+	MaxPod     int32
+	MaxQps     float32
+	SafeQps    float32
+	Factor     float32
+	DryRun     *bool
+}
+
+// NewDynamicConfig 生成通过发现得到的服务配置
+func (c *Config) NewDynamicConfig(spec *ServiceSpec) (*scaleServiceConfig, error) {
+	scaleConfig := &scaleServiceConfig{
+		Namespace:   spec.Namespace,
+		ServiceName: spec.ServiceName,
+		MinPod:      spec.MinPod,
+		MaxPod:      spec.MaxPod,
+		MaxQps:      spec.MaxQps,
+		SafeQps:     spec.SafeQps,
+		Factor:      spec.Factor,
+		DryRun:      spec.DryRun,
+		Discovered:  true,
+	}
+	if spec.Kind != "" {
+		scaleConfig.TargetRef = &targetRefConfig{APIVersion: spec.APIVersion, Kind: spec.Kind, Name: spec.Name}
+	}
+	if err := c.validService(scaleConfig); err != nil {
+		return nil, err
+	}
+	return scaleConfig, nil
+}
+
+// NewAnnotationConfig 根据注解生成服务的伸缩配置,没有开启simple-hpa.io/enabled时返回nil。
+// kind和name不为空时表示注解在工作负载上,直接伸缩该工作负载
+func (c *Config) NewAnnotationConfig(namespace, serviceName, apiVersion, kind, name string,
+	annotations map[string]string) (*scaleServiceConfig, error) {
+	enabled, _ := strconv.ParseBool(annotations[AnnotationEnabled])
+	if !enabled {
+		return nil, nil
+	}
+	spec := &ServiceSpec{
+		Namespace:   namespace,
+		ServiceName: serviceName,
+		APIVersion:  apiVersion,
+		Kind:        kind,
+		Name:        name,
+	}
+	for key, value := range map[string]*float32{
+		annotationMaxQps:  &spec.MaxQps,
+		annotationSafeQps: &spec.SafeQps,
+		annotationFactor:  &spec.Factor,
+	} {
+		if annotations[key] == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(annotations[key], 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s annotation %s error: %w", serviceName, namespace, key, err)
+		}
+		*value = float32(v)
+	}
+	for key, value := range map[string]*int32{
+		annotationMinPod: &spec.MinPod,
+		annotationMaxPod: &spec.MaxPod,
+	} {
+		if annotations[key] == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(annotations[key], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s annotation %s error: %w", serviceName, namespace, key, err)
+		}
+		*value = int32(v)
+	}
+	return c.NewDynamicConfig(spec)
+}
+
+// AddDiscoveredService 添加或更新通过注解发现的服务。配置文件中已有同名服务时以配置文件为准,返回false
+func (c *Config) AddDiscoveredService(scaleConfig *scaleServiceConfig) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i, _conf := range c.ScaleServices {
+		if _conf.ServiceName != scaleConfig.ServiceName || _conf.Namespace != scaleConfig.Namespace {
+			continue
+		}
+		if !_conf.Discovered {
+			return false
+		}
+		c.ScaleServices[i] = scaleConfig
+		return true
+	}
+	c.ScaleServices = append(c.ScaleServices, scaleConfig)
+	return true
+}
+
+// RemoveDiscoveredService 删除通过注解发现的服务,配置文件中的服务不会被删除
+func (c *Config) RemoveDiscoveredService(service string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i, _conf := range c.ScaleServices {
+		if fmt.Sprintf("%s.%s", _conf.ServiceName, _conf.Namespace) != service {
+			continue
+		}
+		if !_conf.Discovered {
+			return false
+		}
+		c.ScaleServices = append(c.ScaleServices[:i], c.ScaleServices[i+1:]...)
+		return true
+	}
+	return false
+}
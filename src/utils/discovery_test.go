@@ -0,0 +1,59 @@
+package utils
+
+import "testing"
+
+func newTestConfig() *Config {
+	return &Config{
+		Default: &DefaultConfig{AvgTime: 5, ScaleIntervalTime: 60, MaxPod: 2, MinPod: 1, MaxQps: 10, SafeQps: 5, Factor: 1},
+		ScaleServices: []*scaleServiceConfig{
+			{ServiceName: "web", Namespace: "demo", MaxPod: 10, MinPod: 2, MaxQps: 20, SafeQps: 10},
+		},
+	}
+}
+
+func TestConfig_NewAnnotationConfig(t *testing.T) {
+	config := newTestConfig()
+	conf, err := config.NewAnnotationConfig("demo", "api", "apps/v1", "Deployment", "api-v2", map[string]string{
+		AnnotationEnabled:           "true",
+		"simple-hpa.io/max-qps":     "30",
+		"simple-hpa.io/max-pod":     "8",
+		"simple-hpa.io/not-support": "x",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.MaxQps != 30 || conf.SafeQps != 5 || conf.MaxPod != 8 || conf.MinPod != 1 || !conf.Discovered {
+		t.Errorf("unexpected config %+v", conf)
+	}
+	if len(conf.TargetRefs) != 1 || conf.TargetRefs[0].String() != "apps/v1/Deployment api-v2" {
+		t.Errorf("want targetRef apps/v1/Deployment api-v2, got %v", conf.TargetRefs)
+	}
+	conf, err = config.NewAnnotationConfig("demo", "api", "", "", "", map[string]string{AnnotationEnabled: "false"})
+	if conf != nil || err != nil {
+		t.Errorf("want disabled, got %v %v", conf, err)
+	}
+	_, err = config.NewAnnotationConfig("demo", "api", "", "", "", map[string]string{
+		AnnotationEnabled: "true", "simple-hpa.io/min-pod": "abc",
+	})
+	if err == nil {
+		t.Error("want error for invalid min-pod")
+	}
+}
+
+func TestConfig_AddDiscoveredService(t *testing.T) {
+	config := newTestConfig()
+	web, _ := config.NewAnnotationConfig("demo", "web", "", "", "", map[string]string{AnnotationEnabled: "true"})
+	if config.AddDiscoveredService(web) {
+		t.Error("config file entry should take precedence")
+	}
+	api, _ := config.NewAnnotationConfig("demo", "api", "", "", "", map[string]string{AnnotationEnabled: "true"})
+	if !config.AddDiscoveredService(api) || config.GetServiceConfig("api.demo") != api {
+		t.Error("want api.demo added")
+	}
+	if config.RemoveDiscoveredService("web.demo") {
+		t.Error("config file entry should not be removed")
+	}
+	if !config.RemoveDiscoveredService("api.demo") || config.GetServiceConfig("api.demo") != nil {
+		t.Error("want api.demo removed")
+	}
+}